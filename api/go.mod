@@ -2,26 +2,30 @@ module github.com/benhall-1/appealscc/api
 
 go 1.17
 
+require (
+	github.com/getsentry/sentry-go v0.11.0
+	github.com/golang-jwt/jwt v3.2.2+incompatible
+	github.com/google/uuid v1.3.0
+	github.com/gorilla/mux v1.8.0
+	github.com/joho/godotenv v1.4.0
+	github.com/ravener/discord-oauth2 v0.0.0-20210928130214-d7697a35c387
+	github.com/sethvargo/go-password v0.2.0
+	github.com/urfave/negroni v1.0.0
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/oauth2 v0.0.0-20211005180243-6b3c2da341f1
+	gopkg.in/yaml.v3 v3.0.1
+	gorm.io/driver/mysql v1.1.2
+	gorm.io/gorm v1.21.16
+)
+
 require (
 	github.com/felixge/httpsnoop v1.0.2 // indirect
-	github.com/getsentry/sentry-go v0.11.0 // indirect
 	github.com/go-sql-driver/mysql v1.6.0 // indirect
-	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/golang/protobuf v1.4.2 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/gorilla/handlers v1.5.1 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.2 // indirect
-	github.com/joho/godotenv v1.4.0 // indirect
-	github.com/ravener/discord-oauth2 v0.0.0-20210928130214-d7697a35c387 // indirect
-	github.com/sethvargo/go-password v0.2.0 // indirect
-	github.com/urfave/negroni v1.0.0 // indirect
-	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
 	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110 // indirect
-	golang.org/x/oauth2 v0.0.0-20211005180243-6b3c2da341f1 // indirect
 	google.golang.org/appengine v1.6.6 // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
-	gorm.io/driver/mysql v1.1.2 // indirect
-	gorm.io/gorm v1.21.16 // indirect
 )
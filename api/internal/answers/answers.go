@@ -0,0 +1,83 @@
+package answers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/benhall-1/appealscc/api/internal/fields"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+// ContentKey is where problems with an appeal's free-form content are reported,
+// as it does not belong to any field.
+const ContentKey = "content"
+
+const maxContentSize = 64 * 1024
+
+// Errors maps the ID of each field which was not answered correctly to what was
+// wrong with it.
+type Errors map[string]string
+
+func (e Errors) add(fieldId uuid.UUID, message string) {
+	e[fieldId.String()] = message
+}
+
+// Validate checks the answers given to an appeal against the fields of its
+// template. Every required field must be answered exactly once, answers to
+// fields the template does not have or which are hidden by their conditions are
+// rejected, and each answer must fit its field's type and settings. Answers are
+// given the type of their field.
+func Validate(template model.AppealTemplate, content json.RawMessage, answers []model.AppealAnswer) Errors {
+	errs := Errors{}
+
+	if len(content) > maxContentSize {
+		errs[ContentKey] = "Content is too large"
+	} else if trimmed := strings.TrimSpace(string(content)); trimmed != "" && trimmed != "null" && !strings.HasPrefix(trimmed, "{") {
+		errs[ContentKey] = "Content must be a JSON object"
+	}
+
+	templateFields := map[uuid.UUID]model.AppealTemplateField{}
+	for _, field := range template.AppealTemplateFields {
+		templateFields[field.ID] = field
+	}
+
+	given := map[uuid.UUID]string{}
+	for i := range answers {
+		answer := &answers[i]
+		field, ok := templateFields[answer.Field]
+		if !ok {
+			errs.add(answer.Field, "This question is not part of the form")
+			continue
+		}
+		if _, duplicate := given[field.ID]; duplicate {
+			errs.add(field.ID, "This question has been answered more than once")
+			continue
+		}
+		answer.Type = field.Type
+		given[field.ID] = answer.Content
+	}
+
+	// Conditions decide which questions apply, so they are worked out from every
+	// answer before any are checked
+	states := fields.Evaluate(template.AppealTemplateFields, given)
+	for _, field := range template.AppealTemplateFields {
+		if _, reported := errs[field.ID.String()]; reported {
+			continue
+		}
+		content, ok := given[field.ID]
+		answered := ok && !fields.IsBlank(field, content)
+
+		if answered && !states[field.ID].Shown {
+			errs.add(field.ID, "This question does not apply to your other answers")
+		} else if !answered && states[field.ID].Required {
+			errs.add(field.ID, "This question is required")
+		} else if answered {
+			if message := fields.Check(field, content); message != "" {
+				errs.add(field.ID, message)
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,101 @@
+package answers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/benhall-1/appealscc/api/internal/fields"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+func TestValidate(t *testing.T) {
+	banned := model.AppealTemplateField{Base: model.Base{ID: uuid.New()}, Key: "banned", Title: "Were you banned?", Type: fields.TypeYesNo, Required: true}
+	reason := model.AppealTemplateField{Base: model.Base{ID: uuid.New()}, Key: "reason", Title: "Why?", Type: fields.TypeShortText,
+		ShowWhen: model.FieldConditions{{Field: "banned", Operator: fields.OperatorEquals, Value: "yes"}}}
+	age := model.AppealTemplateField{Base: model.Base{ID: uuid.New()}, Key: "age", Title: "Age", Type: fields.TypeNumber, Min: float(13)}
+	template := model.AppealTemplate{AppealTemplateFields: []model.AppealTemplateField{banned, reason, age}}
+	unknown := uuid.New()
+
+	tests := []struct {
+		name    string
+		content json.RawMessage
+		answers []model.AppealAnswer
+		want    Errors
+	}{
+		{
+			name:    "valid answers",
+			answers: []model.AppealAnswer{{Field: banned.ID, Content: "yes"}, {Field: reason.ID, Content: "Spamming"}, {Field: age.ID, Content: "18"}},
+			want:    Errors{},
+		},
+		{
+			name: "required question missing",
+			want: Errors{banned.ID.String(): "This question is required"},
+		},
+		{
+			name:    "blank answer to required question",
+			answers: []model.AppealAnswer{{Field: banned.ID, Content: "  "}},
+			want:    Errors{banned.ID.String(): "This question is required"},
+		},
+		{
+			name:    "question not in the form",
+			answers: []model.AppealAnswer{{Field: banned.ID, Content: "no"}, {Field: unknown, Content: "hello"}},
+			want:    Errors{unknown.String(): "This question is not part of the form"},
+		},
+		{
+			name:    "answered twice",
+			answers: []model.AppealAnswer{{Field: banned.ID, Content: "no"}, {Field: banned.ID, Content: "yes"}},
+			want:    Errors{banned.ID.String(): "This question has been answered more than once"},
+		},
+		{
+			name:    "answer to hidden question",
+			answers: []model.AppealAnswer{{Field: banned.ID, Content: "no"}, {Field: reason.ID, Content: "Spamming"}},
+			want:    Errors{reason.ID.String(): "This question does not apply to your other answers"},
+		},
+		{
+			name:    "answer does not fit the type",
+			answers: []model.AppealAnswer{{Field: banned.ID, Content: "maybe"}, {Field: age.ID, Content: "12"}},
+			want:    Errors{banned.ID.String(): "Answer must be yes or no", age.ID.String(): "Answer must be at least 13"},
+		},
+		{
+			name:    "content is not an object",
+			content: json.RawMessage(`["not", "an", "object"]`),
+			answers: []model.AppealAnswer{{Field: banned.ID, Content: "no"}},
+			want:    Errors{ContentKey: "Content must be a JSON object"},
+		},
+		{
+			name:    "null content",
+			content: json.RawMessage(`null`),
+			answers: []model.AppealAnswer{{Field: banned.ID, Content: "no"}},
+			want:    Errors{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Validate(template, test.content, test.answers)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for key, message := range test.want {
+				if got[key] != message {
+					t.Errorf("error for %s is %q, want %q", key, got[key], message)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSetsAnswerTypes(t *testing.T) {
+	field := model.AppealTemplateField{Base: model.Base{ID: uuid.New()}, Key: "name", Title: "Name", Type: fields.TypeShortText}
+	answers := []model.AppealAnswer{{Field: field.ID, Content: "Steve"}}
+
+	Validate(model.AppealTemplate{AppealTemplateFields: []model.AppealTemplateField{field}}, nil, answers)
+	if answers[0].Type != fields.TypeShortText {
+		t.Errorf("answer type is %q, want %q", answers[0].Type, fields.TypeShortText)
+	}
+}
+
+func float(value float64) *float64 {
+	return &value
+}
@@ -0,0 +1,148 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/google/uuid"
+)
+
+const apiKeyPrefix = "acc_"
+
+// Scopes that can be granted to an API key
+const (
+	ScopeAppealsRead    = rbac.PermissionAppealsRead
+	ScopeAppealsRespond = rbac.PermissionAppealsRespond
+	ScopeTemplatesRead  = rbac.PermissionTemplatesRead
+	ScopeTemplatesWrite = rbac.PermissionTemplatesWrite
+)
+
+var APIKeyScopes = []string{ScopeAppealsRead, ScopeAppealsRespond, ScopeTemplatesRead, ScopeTemplatesWrite}
+
+var (
+	ErrInvalidAPIKey  = errors.New("api key is invalid, revoked or has expired")
+	ErrInvalidScope   = errors.New("api key scope is not recognised")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// IsAPIKey reports whether the bearer token looks like an API key rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// parseAPIKey splits a key into the stored prefix and its secret.
+func parseAPIKey(key string) (string, string, bool) {
+	if !IsAPIKey(key) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return apiKeyPrefix + parts[0], parts[1], true
+}
+
+// CreateAPIKey issues a new key bound to one organisation. The returned key is
+// only available now; just its hash and prefix are stored.
+func CreateAPIKey(userId uuid.UUID, organisationId uuid.UUID, name string, scopes []string, expiresAt *time.Time) (string, *model.APIKey, error) {
+	if len(scopes) == 0 {
+		return "", nil, ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !containsScope(APIKeyScopes, scope) {
+			return "", nil, ErrInvalidScope
+		}
+	}
+
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", nil, err
+	}
+	secret, hash, err := GenerateSecureToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	prefix := apiKeyPrefix + hex.EncodeToString(prefixBytes)
+	apiKey := model.APIKey{
+		User:         userId,
+		Organisation: organisationId,
+		Name:         name,
+		Prefix:       prefix,
+		KeyHash:      hash,
+		Scopes:       scopes,
+		ExpiresAt:    expiresAt,
+	}
+	if err := db.DB.Create(&apiKey); err.Error != nil {
+		return "", nil, err.Error
+	}
+
+	return prefix + "_" + secret, &apiKey, nil
+}
+
+// AuthenticateAPIKey returns the key and the user it acts as.
+func AuthenticateAPIKey(key string) (*model.APIKey, *model.User, error) {
+	prefix, secret, ok := parseAPIKey(key)
+	if !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var apiKey model.APIKey
+	if err := db.DB.First(&apiKey, "prefix = ?", prefix); err.Error != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(HashToken(secret))) != 1 {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", apiKey.User); err.Error != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	db.DB.Model(&apiKey).Update("last_used_at", now)
+	apiKey.LastUsedAt = &now
+
+	return &apiKey, &user, nil
+}
+
+// RevokeAPIKey revokes a key belonging to the organisation, optionally only if it
+// was also created by the given user.
+func RevokeAPIKey(keyId uuid.UUID, organisationId *uuid.UUID, userId *uuid.UUID) error {
+	query := db.DB.Model(&model.APIKey{}).Where("id = ? AND revoked_at IS NULL", keyId)
+	if organisationId != nil {
+		query = query.Where("organisation = ?", *organisationId)
+	}
+	if userId != nil {
+		query = query.Where("user = ?", *userId)
+	}
+
+	result := query.Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -1,54 +1,40 @@
 package authentication
 
 import (
-	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/benhall-1/appealscc/api/internal/db"
 	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
-	"github.com/benhall-1/appealscc/api/internal/models/discordmodel"
 	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/tokens"
 	"github.com/getsentry/sentry-go"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/sethvargo/go-password/password"
 )
 
-var jwtKey = []byte(os.Getenv("SECRET"))
-
-func RegisterAccount(user *model.User, discord *discordmodel.DiscordUser) (bool, *model.User) {
-	var userExists *model.User
-	if user == nil {
-		user = &model.User{}
+func RegisterAccount(user *model.User) (bool, *model.User) {
+	if user == nil || user.Email == nil || len(*user.Email) == 0 {
+		return false, nil
 	}
 
-	if len(user.Email) == 0 {
-		if discord != nil {
-			user.Email = discord.Email
-		} else {
-			return false, nil
-		}
+	if err := releaseEmail(*user.Email, uuid.Nil); err != nil {
+		return false, nil
 	}
 
+	return createAccount(user)
+}
+
+func createAccount(user *model.User) (bool, *model.User) {
 	if len(user.Password) == 0 {
 		pass, _ := password.Generate(64, 10, 10, false, false)
 		user.Password = pass
 	}
 
-	if result := db.DB.First(&userExists, "Email = ?", user.Email); result.RowsAffected > 0 {
-		if discord != nil {
-			return true, userExists
-		} else {
-			return false, nil
-		}
-	}
-
-	plainPassword := user.Password
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(plainPassword), 14)
-	user.Password = string(hashedPassword)
+	user.Password = hashPassword(user.Password)
 
 	err := db.DB.Create(&user)
 	if err.Error != nil {
@@ -59,14 +45,20 @@ func RegisterAccount(user *model.User, discord *discordmodel.DiscordUser) (bool,
 	}
 }
 
+func hashPassword(plainPassword string) string {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(plainPassword), 14)
+	return string(hashedPassword)
+}
+
 func GenerateToken(user model.User) (*authmodel.TokenResponse, error) {
 	expirationTime := time.Now().Add(5 * time.Minute)
 	// Create the JWT claims, which includes the username and expiry time
 	claims := &authmodel.Claims{
-		Email:       user.Email,
+		Email:       EmailOf(user),
 		Id:          user.ID.String(),
 		GlobalAdmin: user.GlobalAdmin,
 		PremiumType: user.PremiumType,
+		TwoFactor:   user.TwoFactorEnabled,
 		StandardClaims: jwt.StandardClaims{
 			// In JWT, the expiry time is expressed as unix milliseconds
 			ExpiresAt: expirationTime.Unix(),
@@ -77,9 +69,7 @@ func GenerateToken(user model.User) (*authmodel.TokenResponse, error) {
 		},
 	}
 
-	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := tokens.Sign(claims)
 	if err != nil {
 		sentry.CaptureException(err)
 		return nil, err
@@ -88,14 +78,11 @@ func GenerateToken(user model.User) (*authmodel.TokenResponse, error) {
 	}
 }
 
-func GetCurrentUser(w http.ResponseWriter, r *http.Request) jwt.MapClaims {
-	token := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-
-	tkn, _ := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-
-	claims, _ := tkn.Claims.(jwt.MapClaims)
-
-	return claims
+// EmailOf returns the user's email address, or an empty string for accounts
+// created through a provider that did not share one.
+func EmailOf(user model.User) string {
+	if user.Email == nil {
+		return ""
+	}
+	return *user.Email
 }
@@ -0,0 +1,135 @@
+package authentication
+
+import (
+	"errors"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/oauth"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIdentityLinked   = errors.New("identity is already linked to another account")
+	ErrEmailInUse       = errors.New("an account already uses this email address")
+	ErrLastLoginMethod  = errors.New("identity is the only way to log in to the account")
+	ErrIdentityNotFound = errors.New("identity not found")
+)
+
+// ResolveIdentity returns the user linked to the provider identity, creating a new
+// account for identities that have never been seen before. An identity whose
+// email address the provider has verified is linked to the account with the same
+// address when that account has verified it too; an account which never verified
+// the address loses it to the new account instead. Accounts are never merged by
+// an unverified address and existing users must link the identity themselves.
+func ResolveIdentity(provider string, identity *oauth.Identity, token *oauth2.Token) (*model.User, error) {
+	var externalIdentity model.ExternalIdentity
+	if result := db.DB.Find(&externalIdentity, "provider = ? AND subject_id = ?", provider, identity.SubjectID); result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected > 0 {
+		updateIdentity(&externalIdentity, identity, token)
+		if err := db.DB.Save(&externalIdentity); err.Error != nil {
+			return nil, err.Error
+		}
+
+		var user model.User
+		if err := db.DB.First(&user, "Id = ?", externalIdentity.User); err.Error != nil {
+			return nil, err.Error
+		}
+		return &user, nil
+	}
+
+	user := &model.User{}
+	if identity.Email != "" && identity.EmailVerified {
+		var userExists model.User
+		if result := db.DB.Find(&userExists, "Email = ?", identity.Email); result.Error != nil {
+			return nil, result.Error
+		} else if result.RowsAffected > 0 && userExists.EmailVerified {
+			// Both sides have proven they own the address, so it is the same person
+			externalIdentity = model.ExternalIdentity{User: userExists.ID, Provider: provider}
+			updateIdentity(&externalIdentity, identity, token)
+			if err := db.DB.Create(&externalIdentity); err.Error != nil {
+				return nil, err.Error
+			}
+			return &userExists, nil
+		}
+		if err := releaseEmail(identity.Email, uuid.Nil); err != nil {
+			return nil, err
+		}
+		email := identity.Email
+		user.Email = &email
+		user.EmailVerified = true
+	}
+
+	if status, created := createAccount(user); !status {
+		return nil, errors.New("could not create account")
+	} else {
+		user = created
+	}
+
+	externalIdentity = model.ExternalIdentity{User: user.ID, Provider: provider}
+	updateIdentity(&externalIdentity, identity, token)
+	if err := db.DB.Create(&externalIdentity); err.Error != nil {
+		db.DB.Unscoped().Delete(user)
+		return nil, err.Error
+	}
+
+	return user, nil
+}
+
+// LinkIdentity attaches the provider identity to an existing user.
+func LinkIdentity(userId uuid.UUID, provider string, identity *oauth.Identity, token *oauth2.Token) (*model.ExternalIdentity, error) {
+	var externalIdentity model.ExternalIdentity
+	if result := db.DB.Find(&externalIdentity, "provider = ? AND subject_id = ?", provider, identity.SubjectID); result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected > 0 && externalIdentity.User != userId {
+		return nil, ErrIdentityLinked
+	}
+
+	externalIdentity.User = userId
+	externalIdentity.Provider = provider
+	updateIdentity(&externalIdentity, identity, token)
+	if err := db.DB.Save(&externalIdentity); err.Error != nil {
+		return nil, err.Error
+	}
+
+	return &externalIdentity, nil
+}
+
+// UnlinkIdentity removes a linked identity, refusing to remove the last one from
+// an account which has no email address to log in with.
+func UnlinkIdentity(userId uuid.UUID, identityId uuid.UUID) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var user model.User
+		if err := tx.Preload("ExternalIdentities").First(&user, "Id = ?", userId); err.Error != nil {
+			return err.Error
+		}
+
+		found := false
+		for _, externalIdentity := range user.ExternalIdentities {
+			if externalIdentity.ID == identityId {
+				found = true
+			}
+		}
+		if !found {
+			return ErrIdentityNotFound
+		}
+
+		if user.Email == nil && len(user.ExternalIdentities) == 1 {
+			return ErrLastLoginMethod
+		}
+
+		return tx.Unscoped().Delete(&model.ExternalIdentity{}, "id = ?", identityId).Error
+	})
+}
+
+func updateIdentity(externalIdentity *model.ExternalIdentity, identity *oauth.Identity, token *oauth2.Token) {
+	externalIdentity.SubjectID = identity.SubjectID
+	externalIdentity.Username = identity.Username
+	externalIdentity.Avatar = identity.Avatar
+	externalIdentity.AccessToken = token.AccessToken
+	externalIdentity.RefreshToken = token.RefreshToken
+	externalIdentity.TokenExpiry = token.Expiry
+}
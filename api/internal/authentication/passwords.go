@@ -0,0 +1,122 @@
+package authentication
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/mailer"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	passwordResetLifetime    = time.Hour
+	passwordResetResendDelay = time.Minute
+	minimumPasswordLength    = 8
+)
+
+var (
+	ErrInvalidResetToken = errors.New("password reset token is invalid or has expired")
+	ErrIncorrectPassword = errors.New("current password is incorrect")
+	ErrWeakPassword      = fmt.Errorf("password must be at least %d characters", minimumPasswordLength)
+)
+
+// RequestPasswordReset emails a single-use reset link to the account using the
+// email address. Nothing is sent when no such account exists.
+func RequestPasswordReset(email string) error {
+	var user model.User
+	if result := db.DB.Find(&user, "Email = ?", email); result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	var recent model.PasswordReset
+	if result := db.DB.Find(&recent, "user = ? AND created_at > ?", user.ID, time.Now().Add(-passwordResetResendDelay)); result.RowsAffected > 0 {
+		return nil
+	}
+
+	token, hash, err := GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+
+	reset := model.PasswordReset{
+		User:      user.ID,
+		Email:     *user.Email,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(passwordResetLifetime),
+	}
+	if err := db.DB.Create(&reset); err.Error != nil {
+		return err.Error
+	}
+
+	return mailer.Send(email, "Reset your AppealsCC password", fmt.Sprintf(
+		"Someone asked to reset the password for your AppealsCC account.\n\nChoose a new password by visiting the link below within the next hour:\n\n%s\n\nIf this wasn't you, you can ignore this email.",
+		FrontendLink("/reset-password", token),
+	))
+}
+
+// ResetPassword sets a new password using a reset token, invalidating every other
+// outstanding reset token and logging the user out everywhere. The user's email
+// address is verified too, provided it is still the one the link was sent to.
+func ResetPassword(token string, newPassword string) error {
+	if len(newPassword) < minimumPasswordLength {
+		return ErrWeakPassword
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var reset model.PasswordReset
+		if err := tx.First(&reset, "token_hash = ?", HashToken(token)); err.Error != nil {
+			return ErrInvalidResetToken
+		}
+		if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+
+		if err := tx.Model(&model.PasswordReset{}).Where("user = ? AND used_at IS NULL", reset.User).Update("used_at", time.Now()); err.Error != nil {
+			return err.Error
+		}
+
+		var user model.User
+		if err := tx.First(&user, "Id = ?", reset.User); err.Error != nil {
+			return ErrInvalidResetToken
+		}
+
+		updates := map[string]interface{}{"password": hashPassword(newPassword)}
+		// The reset link was delivered by email, so following it proves the address is theirs
+		if sentTo(user, reset.Email) {
+			updates["email_verified"] = true
+		}
+		if err := tx.Model(&user).Updates(updates); err.Error != nil {
+			return err.Error
+		}
+
+		return tx.Model(&model.RefreshToken{}).Where("user = ? AND revoked_at IS NULL", reset.User).Update("revoked_at", time.Now()).Error
+	})
+}
+
+// ChangePassword replaces the password of a logged in user after checking their
+// current one, and logs them out of every existing session.
+func ChangePassword(userId uuid.UUID, currentPassword string, newPassword string) error {
+	if len(newPassword) < minimumPasswordLength {
+		return ErrWeakPassword
+	}
+
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", userId); err.Error != nil {
+		return err.Error
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+		return ErrIncorrectPassword
+	}
+
+	if err := db.DB.Model(&user).Update("password", hashPassword(newPassword)); err.Error != nil {
+		return err.Error
+	}
+
+	return RevokeAllRefreshTokens(user.ID)
+}
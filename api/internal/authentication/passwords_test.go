@@ -0,0 +1,36 @@
+package authentication
+
+import (
+	"testing"
+
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+)
+
+// A reset link only verifies the address it was sent to. Otherwise someone could
+// ask for a reset to their own address, change their account's address to
+// someone else's, then follow the link to mark that address as verified.
+func TestResetVerifiesOnlyTheAddressItWasSentTo(t *testing.T) {
+	address := func(email string) *string { return &email }
+
+	tests := []struct {
+		name    string
+		current *string
+		sentTo  string
+		want    bool
+	}{
+		{"address unchanged", address("player@example.com"), "player@example.com", true},
+		{"address differs only in case", address("Player@Example.com"), "player@example.com", true},
+		{"address changed after the link was sent", address("victim@example.com"), "attacker@example.com", false},
+		{"address removed after the link was sent", nil, "player@example.com", false},
+		{"link sent before addresses were recorded", address("player@example.com"), "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reset := model.PasswordReset{Email: test.sentTo}
+			if got := sentTo(model.User{Email: test.current}, reset.Email); got != test.want {
+				t.Errorf("sentTo is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package authentication
+
+import (
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/principal"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/benhall-1/appealscc/api/internal/tokens"
+	"github.com/google/uuid"
+)
+
+// Authenticate resolves a bearer token, either a JWT or an API key, to the
+// principal making the request.
+func Authenticate(token string) (*principal.Principal, error) {
+	if IsAPIKey(token) {
+		apiKey, user, err := AuthenticateAPIKey(token)
+		if err != nil {
+			return nil, err
+		}
+
+		// API keys never act with the global admin rights of the user who created them
+		organisationId := apiKey.Organisation
+		apiKeyId := apiKey.ID
+		p := &principal.Principal{
+			UserID:             user.ID,
+			Email:              EmailOf(*user),
+			PremiumType:        user.PremiumType,
+			TwoFactor:          user.TwoFactorEnabled,
+			AuthMethod:         principal.AuthMethodAPIKey,
+			APIKeyID:           &apiKeyId,
+			APIKeyOrganisation: &organisationId,
+			Scopes:             apiKey.Scopes,
+		}
+		return p, loadMemberships(p)
+	}
+
+	claims := &authmodel.Claims{}
+	if _, err := tokens.Parse(token, claims); err != nil {
+		return nil, err
+	}
+	userId, err := uuid.Parse(claims.Id)
+	if err != nil {
+		return nil, tokens.ErrInvalidToken
+	}
+
+	p := &principal.Principal{
+		UserID:      userId,
+		Email:       claims.Email,
+		GlobalAdmin: claims.GlobalAdmin,
+		PremiumType: claims.PremiumType,
+		TwoFactor:   claims.TwoFactor,
+		AuthMethod:  principal.AuthMethodToken,
+	}
+	if p.GlobalAdmin {
+		p.Roles = append(p.Roles, principal.RoleGlobalAdmin)
+	}
+	return p, loadMemberships(p)
+}
+
+func loadMemberships(p *principal.Principal) error {
+	p.Memberships = map[uuid.UUID]principal.Membership{}
+
+	var members []model.OrganisationMember
+	if err := db.DB.Preload("CustomRole").Find(&members, "user = ?", p.UserID); err.Error != nil {
+		return err.Error
+	}
+	for _, member := range members {
+		p.Memberships[member.Organisation] = principal.Membership{Role: member.Role, Permissions: rbac.PermissionsOf(member)}
+	}
+
+	var owned []uuid.UUID
+	if err := db.DB.Model(&model.Organisation{}).Where("owner_id = ?", p.UserID).Pluck("id", &owned); err.Error != nil {
+		return err.Error
+	}
+	for _, organisationId := range owned {
+		p.Memberships[organisationId] = principal.Membership{Role: rbac.RoleOwner, Permissions: rbac.BuiltInRoles[rbac.RoleOwner]}
+	}
+
+	if len(p.Memberships) == 0 {
+		return nil
+	}
+
+	organisationIds := make([]uuid.UUID, 0, len(p.Memberships))
+	for organisationId := range p.Memberships {
+		organisationIds = append(organisationIds, organisationId)
+	}
+	var requiringTwoFactor []uuid.UUID
+	if err := db.DB.Model(&model.Organisation{}).Where("id IN ? AND require_two_factor = ?", organisationIds, true).Pluck("id", &requiringTwoFactor); err.Error != nil {
+		return err.Error
+	}
+	for _, organisationId := range requiringTwoFactor {
+		membership := p.Memberships[organisationId]
+		membership.TwoFactorRequired = true
+		p.Memberships[organisationId] = membership
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+)
+
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+// GenerateSecureToken returns a random URL-safe token along with the hash that
+// should be stored in the database in its place.
+func GenerateSecureToken() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(bytes)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateSession issues a short-lived access token alongside a new refresh token
+// family for the user.
+func CreateSession(user model.User, r *http.Request) (*authmodel.TokenResponse, error) {
+	return issueTokens(user, uuid.New(), r)
+}
+
+// RotateRefreshToken exchanges a refresh token for a new access and refresh
+// token. Presenting a refresh token that has already been rotated revokes every
+// token in its family, as it means the token has been copied.
+func RotateRefreshToken(token string, r *http.Request) (*authmodel.TokenResponse, error) {
+	var refreshToken model.RefreshToken
+	if err := db.DB.First(&refreshToken, "token_hash = ?", HashToken(token)); err.Error != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if refreshToken.RevokedAt != nil || time.Now().After(refreshToken.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	result := db.DB.Model(&model.RefreshToken{}).Where("id = ? AND rotated_at IS NULL", refreshToken.ID).Update("rotated_at", now)
+	if result.Error != nil {
+		sentry.CaptureException(result.Error)
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := RevokeRefreshTokenFamily(refreshToken.Family); err != nil {
+			sentry.CaptureException(err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", refreshToken.User); err.Error != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return issueTokens(user, refreshToken.Family, r)
+}
+
+// RevokeRefreshToken ends the session the given refresh token belongs to.
+func RevokeRefreshToken(token string) error {
+	var refreshToken model.RefreshToken
+	if err := db.DB.First(&refreshToken, "token_hash = ?", HashToken(token)); err.Error != nil {
+		return ErrInvalidRefreshToken
+	}
+	return RevokeRefreshTokenFamily(refreshToken.Family)
+}
+
+func RevokeRefreshTokenFamily(family uuid.UUID) error {
+	return db.DB.Model(&model.RefreshToken{}).Where("family = ? AND revoked_at IS NULL", family).Update("revoked_at", time.Now()).Error
+}
+
+func RevokeAllRefreshTokens(userId uuid.UUID) error {
+	return db.DB.Model(&model.RefreshToken{}).Where("user = ? AND revoked_at IS NULL", userId).Update("revoked_at", time.Now()).Error
+}
+
+func issueTokens(user model.User, family uuid.UUID, r *http.Request) (*authmodel.TokenResponse, error) {
+	tokenResponse, err := GenerateToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	token, hash, err := GenerateSecureToken()
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil, err
+	}
+
+	refreshToken := model.RefreshToken{
+		User:      user.ID,
+		Family:    family,
+		TokenHash: hash,
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+		ExpiresAt: time.Now().Add(refreshTokenLifetime),
+	}
+	if err := db.DB.Create(&refreshToken); err.Error != nil {
+		sentry.CaptureException(err.Error)
+		return nil, err.Error
+	}
+
+	tokenResponse.RefreshToken = token
+	tokenResponse.RefreshExpiration = refreshToken.ExpiresAt
+	return tokenResponse, nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
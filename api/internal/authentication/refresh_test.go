@@ -0,0 +1,65 @@
+package authentication
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGenerateSecureToken(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		token, hash, err := GenerateSecureToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) != 43 {
+			t.Errorf("token %q is %d characters, want 43", token, len(token))
+		}
+		if hash != HashToken(token) {
+			t.Errorf("hash is %q, want the hash of the token %q", hash, HashToken(token))
+		}
+		if seen[token] {
+			t.Errorf("token %q was generated twice", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.token, func(t *testing.T) {
+			if got := HashToken(test.token); got != test.want {
+				t.Errorf("hash is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"203.0.113.7:52114", "203.0.113.7"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"203.0.113.7", "203.0.113.7"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.remoteAddr, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/auth/refresh", nil)
+			r.RemoteAddr = test.remoteAddr
+			if got := clientIP(r); got != test.want {
+				t.Errorf("client IP is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/totp"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	challengeLifetime    = 5 * time.Minute
+	challengeMaxAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorDisabled    = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorRequired    = errors.New("an organisation you moderate requires two-factor authentication")
+	ErrInvalidTwoFactor     = errors.New("two-factor code is incorrect")
+	ErrInvalidChallenge     = errors.New("two-factor challenge is invalid or has expired")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor enrolment has not been started")
+)
+
+// BeginLogin either issues a session for the user or, when they have two-factor
+// authentication enabled, a challenge that must be completed with a code first.
+func BeginLogin(user model.User, r *http.Request) (*authmodel.TokenResponse, *authmodel.TwoFactorChallengeResponse, error) {
+	if !user.TwoFactorEnabled {
+		tokenResponse, err := CreateSession(user, r)
+		return tokenResponse, nil, err
+	}
+
+	token, hash, err := GenerateSecureToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db.DB.Unscoped().Where("expires_at < ?", time.Now()).Delete(&model.TwoFactorChallenge{})
+
+	challenge := model.TwoFactorChallenge{
+		User:      user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(challengeLifetime),
+	}
+	if err := db.DB.Create(&challenge); err.Error != nil {
+		return nil, nil, err.Error
+	}
+
+	return nil, &authmodel.TwoFactorChallengeResponse{TwoFactorRequired: true, ChallengeToken: token, Expiration: challenge.ExpiresAt}, nil
+}
+
+// CompleteTwoFactorLogin checks the code for a login challenge and issues a
+// session once it is correct. Each challenge can only be attempted a few times.
+func CompleteTwoFactorLogin(challengeToken string, code string, r *http.Request) (*authmodel.TokenResponse, error) {
+	var challenge model.TwoFactorChallenge
+	if err := db.DB.First(&challenge, "token_hash = ?", HashToken(challengeToken)); err.Error != nil {
+		return nil, ErrInvalidChallenge
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrInvalidChallenge
+	}
+
+	// Attempts are only counted while some remain, so concurrent guesses cannot
+	// all get through on the same last attempt
+	result := db.DB.Model(&model.TwoFactorChallenge{}).Where("id = ? AND attempts < ?", challenge.ID, challengeMaxAttempts).Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidChallenge
+	}
+
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", challenge.User); err.Error != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	if ok, err := verifySecondFactor(&user, code); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInvalidTwoFactor
+	}
+
+	if result := db.DB.Unscoped().Delete(&model.TwoFactorChallenge{}, "id = ?", challenge.ID); result.RowsAffected == 0 {
+		return nil, ErrInvalidChallenge
+	}
+
+	return CreateSession(user, r)
+}
+
+// BeginTwoFactorEnrolment generates a new secret for the user. It is not used to
+// log in until ConfirmTwoFactorEnrolment has been called with a valid code.
+func BeginTwoFactorEnrolment(userId uuid.UUID) (*authmodel.TwoFactorEnrolmentResponse, error) {
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", userId); err.Error != nil {
+		return nil, err.Error
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.DB.Model(&user).Update("two_factor_secret", secret); err.Error != nil {
+		return nil, err.Error
+	}
+
+	accountName := EmailOf(user)
+	if accountName == "" {
+		accountName = user.ID.String()
+	}
+
+	return &authmodel.TwoFactorEnrolmentResponse{Secret: secret, Uri: totp.URI(secret, accountName, twoFactorIssuer())}, nil
+}
+
+// ConfirmTwoFactorEnrolment enables two-factor authentication once the user has
+// proved their authenticator works, returning their recovery codes.
+func ConfirmTwoFactorEnrolment(userId uuid.UUID, code string) ([]string, error) {
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", userId); err.Error != nil {
+		return nil, err.Error
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TwoFactorSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := totp.Validate(code, user.TwoFactorSecret, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactor
+	}
+
+	if err := db.DB.Model(&user).Updates(map[string]interface{}{"two_factor_enabled": true, "two_factor_last_step": step}); err.Error != nil {
+		return nil, err.Error
+	}
+
+	return replaceRecoveryCodes(user.ID)
+}
+
+// DisableTwoFactor turns off two-factor authentication after checking a code,
+// unless an organisation the user helps run requires it.
+func DisableTwoFactor(userId uuid.UUID, code string) error {
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", userId); err.Error != nil {
+		return err.Error
+	}
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorDisabled
+	}
+
+	if ok, err := verifySecondFactor(&user, code); err != nil {
+		return err
+	} else if !ok {
+		return ErrInvalidTwoFactor
+	}
+
+	var requiredBy int64
+	db.DB.Model(&model.Organisation{}).
+		Where("require_two_factor = ? AND (owner_id = ? OR id IN (?))", true, user.ID,
+			db.DB.Model(&model.OrganisationMember{}).Select("organisation").Where("user = ?", user.ID)).
+		Count(&requiredBy)
+	if requiredBy > 0 {
+		return ErrTwoFactorRequired
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"two_factor_enabled": false, "two_factor_secret": "", "two_factor_last_step": 0}); err.Error != nil {
+			return err.Error
+		}
+		return tx.Unscoped().Delete(&model.RecoveryCode{}, "user = ?", user.ID).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces every recovery code the user has after
+// checking a code from their authenticator.
+func RegenerateRecoveryCodes(userId uuid.UUID, code string) ([]string, error) {
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", userId); err.Error != nil {
+		return nil, err.Error
+	}
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorDisabled
+	}
+
+	if ok, err := verifySecondFactor(&user, code); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInvalidTwoFactor
+	}
+
+	return replaceRecoveryCodes(user.ID)
+}
+
+// verifySecondFactor accepts either a code from the user's authenticator, which
+// can't be reused, or one of their unused recovery codes.
+func verifySecondFactor(user *model.User, code string) (bool, error) {
+	if step, ok := totp.Validate(code, user.TwoFactorSecret, time.Now()); ok {
+		result := db.DB.Model(&model.User{}).Where("id = ? AND two_factor_last_step < ?", user.ID, step).Update("two_factor_last_step", step)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		return result.RowsAffected > 0, nil
+	}
+
+	result := db.DB.Model(&model.RecoveryCode{}).
+		Where("user = ? AND code_hash = ? AND used_at IS NULL", user.ID, HashToken(normaliseRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func replaceRecoveryCodes(userId uuid.UUID) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	recoveryCodes := make([]model.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, 5)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))
+		codes[i] = code[:4] + "-" + code[4:]
+		recoveryCodes[i] = model.RecoveryCode{User: userId, CodeHash: HashToken(code)}
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&model.RecoveryCode{}, "user = ?", userId); err.Error != nil {
+			return err.Error
+		}
+		return tx.Create(&recoveryCodes).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func normaliseRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func twoFactorIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "AppealsCC"
+}
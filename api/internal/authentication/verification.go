@@ -0,0 +1,169 @@
+package authentication
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/mailer"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+const (
+	verificationLifetime     = 24 * time.Hour
+	verificationResendDelay  = time.Minute
+	verificationDailyLimit   = 5
+	verificationEmailSubject = "Verify your AppealsCC email address"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("verification token is invalid or has expired")
+	ErrVerificationThrottled    = errors.New("too many verification emails have been requested")
+	ErrAlreadyVerified          = errors.New("email address is already verified")
+)
+
+// EmailVerificationRequired reports whether users must verify their email address
+// before logging in or submitting appeals.
+func EmailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// SendVerificationEmail emails the user a single-use link to verify their address,
+// refusing to send another if one was sent too recently.
+func SendVerificationEmail(user model.User) error {
+	if user.Email == nil {
+		return ErrInvalidVerificationToken
+	}
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+
+	var recent []model.EmailVerification
+	if err := db.DB.Order("created_at desc").Find(&recent, "user = ? AND created_at > ?", user.ID, time.Now().Add(-24*time.Hour)); err.Error != nil {
+		return err.Error
+	}
+	if len(recent) >= verificationDailyLimit || (len(recent) > 0 && time.Since(recent[0].CreatedAt) < verificationResendDelay) {
+		return ErrVerificationThrottled
+	}
+
+	token, hash, err := GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+
+	verification := model.EmailVerification{
+		User:      user.ID,
+		Email:     *user.Email,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(verificationLifetime),
+	}
+	if err := db.DB.Create(&verification); err.Error != nil {
+		return err.Error
+	}
+
+	return mailer.Send(*user.Email, verificationEmailSubject, fmt.Sprintf(
+		"Welcome to AppealsCC!\n\nConfirm your email address by visiting the link below within 24 hours:\n\n%s\n\nIf you did not create an account you can ignore this email.",
+		FrontendLink("/verify-email", token),
+	))
+}
+
+// SetEmail gives the user a new email address to verify, for accounts created
+// through a provider which did not share a verified address. Accounts which have
+// already verified their address keep it.
+func SetEmail(userId uuid.UUID, email string) error {
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", userId); err.Error != nil {
+		return err.Error
+	}
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+	if err := releaseEmail(email, user.ID); err != nil {
+		return err
+	}
+
+	if err := db.DB.Model(&user).Update("email", email); err.Error != nil {
+		return err.Error
+	}
+	user.Email = &email
+
+	// Reset links sent to the old address must not verify the new one
+	if err := db.DB.Model(&model.PasswordReset{}).Where("user = ? AND used_at IS NULL", user.ID).Update("used_at", time.Now()); err.Error != nil {
+		return err.Error
+	}
+	return SendVerificationEmail(user)
+}
+
+// releaseEmail takes the address away from any other account which has not
+// verified it, so an address is only held for good by whoever proves they own
+// it. Links sent to those accounts stop working, as their address has changed.
+func releaseEmail(email string, userId uuid.UUID) error {
+	var holders []model.User
+	if err := db.DB.Find(&holders, "Email = ? AND Id <> ?", email, userId); err.Error != nil {
+		return err.Error
+	}
+	for _, holder := range holders {
+		if holder.EmailVerified {
+			return ErrEmailInUse
+		}
+	}
+	if len(holders) == 0 {
+		return nil
+	}
+
+	return db.DB.Model(&model.User{}).Where("Email = ? AND Id <> ? AND email_verified = ?", email, userId, false).Update("email", nil).Error
+}
+
+// VerifyEmail consumes a verification token and marks the user's email address
+// as verified, provided it has not changed since the token was issued.
+func VerifyEmail(token string) (*model.User, error) {
+	var verification model.EmailVerification
+	if err := db.DB.First(&verification, "token_hash = ?", HashToken(token)); err.Error != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+	if verification.UsedAt != nil || time.Now().After(verification.ExpiresAt) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	result := db.DB.Model(&model.EmailVerification{}).Where("id = ? AND used_at IS NULL", verification.ID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", verification.User); err.Error != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+	if !sentTo(user, verification.Email) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user.EmailVerified = true
+	if err := db.DB.Model(&user).Update("email_verified", true); err.Error != nil {
+		return nil, err.Error
+	}
+
+	return &user, nil
+}
+
+// sentTo reports whether the user still has the email address a link was sent
+// to, so following the link proves they own their current address.
+func sentTo(user model.User, email string) bool {
+	return user.Email != nil && email != "" && strings.EqualFold(*user.Email, email)
+}
+
+// FrontendLink builds a link to a page on the frontend carrying a single-use token.
+func FrontendLink(path string, token string) string {
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "https://appeals.cc"
+	}
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimSuffix(frontendURL, "/"), path, token)
+}
@@ -0,0 +1,11 @@
+package config
+
+import "os"
+
+// EnvOrDefault returns the environment variable, or fallback when it is not set.
+func EnvOrDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
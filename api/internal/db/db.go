@@ -1,6 +1,7 @@
 package db
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 
+	"github.com/benhall-1/appealscc/api/internal/fields"
 	"github.com/benhall-1/appealscc/api/internal/models/model"
 	"github.com/getsentry/sentry-go"
 )
@@ -41,6 +43,237 @@ func Open() error {
 	return nil
 }
 
-func Migrate() {
-	DB.AutoMigrate(model.User{}, model.Organisation{}, model.Appeal{}, model.AppealResponse{}, model.AppealTemplate{}, model.AppealTemplateField{})
+// Migrate brings the database up to date with the models, then moves any data
+// stored the way older versions did. It stops at the first step which fails, as
+// later steps rely on earlier ones.
+func Migrate() error {
+	// Checked before the column is added, as only accounts made before email
+	// verification existed are trusted without it
+	legacyEmails := DB.Migrator().HasTable(&model.User{}) && !DB.Migrator().HasColumn(&model.User{}, "email_verified")
+
+	if err := DB.AutoMigrate(model.User{}, model.Organisation{}, model.Appeal{}, model.AppealResponse{}, model.AppealTemplate{}, model.AppealTemplateField{}, model.RefreshToken{}, model.LoginAttempt{}, model.ExternalIdentity{}, model.EmailVerification{}, model.PasswordReset{}, model.RecoveryCode{}, model.TwoFactorChallenge{}, model.APIKey{}, model.SigningKey{}, model.OrganisationRole{}, model.OrganisationMember{}, model.OrganisationInvite{}, model.OwnershipTransfer{}, model.CustomDomain{}, model.AppealTransition{}, model.AppealTemplateVersion{}, model.AppealTemplateSection{}); err != nil {
+		return fmt.Errorf("migrating models: %w", err)
+	}
+
+	steps := []struct {
+		name    string
+		migrate func() error
+	}{
+		{"legacy identities", migrateLegacyIdentities},
+		{"legacy emails", func() error { return migrateLegacyEmails(legacyEmails) }},
+		{"moderators", migrateModerators},
+		{"appeal statuses", migrateAppealStatuses},
+		{"appeal status times", migrateStatusChangedAt},
+		{"file links", migrateFileLinks},
+		{"field types", migrateFieldTypes},
+		{"field keys", migrateFieldKeys},
+		{"template versions", migrateTemplateVersions},
+		{"template statuses", migrateTemplateStatuses},
+	}
+	for _, step := range steps {
+		if err := step.migrate(); err != nil {
+			return fmt.Errorf("migrating %s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateLegacyIdentities moves the Minecraft players linked to accounts before
+// identities existed into linked identities, so their owners can still log in
+// with them, then drops the old columns.
+func migrateLegacyIdentities() error {
+	if !DB.Migrator().HasColumn(&model.User{}, "minecraft_uuid") {
+		return nil
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var legacy int64
+		if err := tx.Table("users").Where("minecraft_uuid IS NOT NULL AND minecraft_uuid <> ''").Count(&legacy); err.Error != nil {
+			return err.Error
+		}
+
+		err := tx.Exec(`INSERT INTO external_identities (id, created_at, updated_at, user, provider, subject_id, username, avatar, access_token, refresh_token, token_expiry)
+			SELECT UUID(), NOW(), NOW(), users.id, 'minecraft', users.minecraft_uuid, COALESCE(users.minecraft_username, ''), '', '', '', NOW()
+			FROM users
+			WHERE users.minecraft_uuid IS NOT NULL AND users.minecraft_uuid <> '' AND NOT EXISTS (
+				SELECT 1 FROM external_identities WHERE external_identities.provider = 'minecraft' AND external_identities.subject_id = users.minecraft_uuid
+			)`)
+		if err.Error != nil {
+			return err.Error
+		}
+
+		// Only drop the old columns once every linked player has an identity
+		var moved int64
+		if err := tx.Table("users").Where("EXISTS (SELECT 1 FROM external_identities WHERE external_identities.provider = 'minecraft' AND external_identities.subject_id = users.minecraft_uuid)").Count(&moved); err.Error != nil {
+			return err.Error
+		}
+		if moved != legacy {
+			return fmt.Errorf("moved %d of %d linked Minecraft players", moved, legacy)
+		}
+
+		if err := tx.Migrator().DropColumn(&model.User{}, "minecraft_uuid"); err != nil {
+			return err
+		}
+		if tx.Migrator().HasColumn(&model.User{}, "minecraft_username") {
+			return tx.Migrator().DropColumn(&model.User{}, "minecraft_username")
+		}
+		return nil
+	})
+}
+
+// migrateLegacyEmails treats the email addresses of accounts made before email
+// verification existed as verified. Logging in with Discord used to sign in to
+// whichever account had the same address, so this keeps those accounts reachable
+// now that a provider's address is only linked to verified accounts.
+func migrateLegacyEmails(legacy bool) error {
+	if !legacy {
+		return nil
+	}
+	return DB.Model(&model.User{}).Where("email IS NOT NULL AND email <> ''").Update("email_verified", true).Error
+}
+
+// migrateModerators moves users from the old moderators join table into
+// organisation members with the moderator role. The old table is kept under
+// another name, and only once every moderator is a member.
+func migrateModerators() error {
+	if !DB.Migrator().HasTable("organisation_moderators") {
+		return nil
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT IGNORE INTO organisation_members (id, created_at, updated_at, organisation, user, role)
+			SELECT UUID(), NOW(), NOW(), organisation_id, user_id, 'moderator' FROM organisation_moderators`)
+		if err.Error != nil {
+			return err.Error
+		}
+
+		// Moderators skipped by the insert must already be members
+		var missing int64
+		if err := tx.Table("organisation_moderators").Where(`NOT EXISTS (
+			SELECT 1 FROM organisation_members WHERE organisation_members.organisation = organisation_moderators.organisation_id
+			AND organisation_members.user = organisation_moderators.user_id AND organisation_members.deleted_at IS NULL
+		)`).Count(&missing); err.Error != nil {
+			return err.Error
+		}
+		if missing > 0 {
+			return fmt.Errorf("%d moderators could not be made members", missing)
+		}
+
+		return tx.Migrator().RenameTable("organisation_moderators", "organisation_moderators_legacy")
+	})
+}
+
+// migrateAppealStatuses replaces the old numeric appeal statuses with named ones.
+// The numbers never had a defined meaning beyond 0 being new, so any appeal
+// which had been given a decision goes back to moderators to be reviewed. The
+// old statuses and the decisions given in responses are kept in each appeal's
+// history before their columns are dropped.
+func migrateAppealStatuses() error {
+	if DB.Migrator().HasColumn(&model.Appeal{}, "appeal_status") {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("UPDATE appeals SET status = CASE WHEN appeal_status = 0 THEN 'submitted' ELSE 'under_review' END"); err.Error != nil {
+				return err.Error
+			}
+			return tx.Exec(`INSERT INTO appeal_transitions (id, created_at, updated_at, appeal, from_status, to_status, actor_type, reason)
+				SELECT UUID(), appeals.updated_at, NOW(), appeals.id, '', appeals.status, 'system', CONCAT('Legacy status ', appeals.appeal_status)
+				FROM appeals
+				WHERE NOT EXISTS (
+					SELECT 1 FROM appeal_transitions WHERE appeal_transitions.appeal = appeals.id AND appeal_transitions.reason LIKE 'Legacy status %'
+				)`).Error
+		})
+		if err != nil {
+			return err
+		}
+		if err := DB.Migrator().DropColumn(&model.Appeal{}, "appeal_status"); err != nil {
+			return err
+		}
+	}
+
+	if DB.Migrator().HasColumn(&model.AppealResponse{}, "decision") {
+		err := DB.Exec(`INSERT INTO appeal_transitions (id, created_at, updated_at, appeal, from_status, to_status, actor_type, actor, response, reason)
+			SELECT UUID(), appeal_responses.created_at, NOW(), appeals.id, appeals.status, appeals.status, 'moderator', appeal_responses.author, appeal_responses.id, CONCAT('Legacy decision ', appeal_responses.decision)
+			FROM appeal_responses
+			JOIN appeals ON appeals.id = appeal_responses.appeal
+			WHERE appeal_responses.decision <> 0 AND NOT EXISTS (
+				SELECT 1 FROM appeal_transitions WHERE appeal_transitions.response = appeal_responses.id
+			)`)
+		if err.Error != nil {
+			return err.Error
+		}
+		return DB.Migrator().DropColumn(&model.AppealResponse{}, "decision")
+	}
+	return nil
+}
+
+// migrateStatusChangedAt sets when appeals made before it was recorded entered
+// their status, from the last move into it or else from when they were made.
+func migrateStatusChangedAt() error {
+	return DB.Exec(`UPDATE appeals SET status_changed_at = COALESCE((
+		SELECT MAX(appeal_transitions.created_at) FROM appeal_transitions
+		WHERE appeal_transitions.appeal = appeals.id AND appeal_transitions.to_status = appeals.status
+	), appeals.created_at)
+	WHERE status_changed_at IS NULL`).Error
+}
+
+// migrateFileLinks renames file questions to file links, which is all they ever
+// were, moving the extensions they allowed out of their options. It runs before
+// migrateFieldTypes, which would otherwise turn them into short text.
+func migrateFileLinks() error {
+	return DB.Model(&model.AppealTemplateField{}).Where("type = ?", "file").Updates(map[string]interface{}{
+		"type":       fields.TypeFileLink,
+		"extensions": gorm.Expr("options"),
+		"options":    nil,
+	}).Error
+}
+
+// migrateFieldTypes gives fields created before types were fixed the type they
+// were used as, falling back to short text for anything unrecognised.
+func migrateFieldTypes() error {
+	err := DB.Model(&model.AppealTemplateField{}).Where("type IN ?", []string{"textarea", "paragraph"}).Update("type", fields.TypeLongText)
+	if err.Error != nil {
+		return err.Error
+	}
+
+	return DB.Model(&model.AppealTemplateField{}).Where("type NOT IN ? OR type IS NULL", fields.Types).Update("type", fields.TypeShortText).Error
+}
+
+// migrateFieldKeys gives fields created before conditions existed a key, so that
+// other fields can refer to them.
+func migrateFieldKeys() error {
+	return DB.Exec("UPDATE appeal_template_fields SET field_key = CONCAT('field_', REPLACE(id, '-', '_')) WHERE field_key IS NULL OR field_key = ''").Error
+}
+
+// migrateTemplateVersions makes the questions of templates created before
+// versioning their first version, and pins their appeals to it.
+func migrateTemplateVersions() error {
+	templates := []model.AppealTemplate{}
+	if err := DB.Where("version = 0 OR version IS NULL").Find(&templates); err.Error != nil {
+		return err.Error
+	}
+
+	for _, template := range templates {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			version := model.AppealTemplateVersion{Template: template.ID, Number: 1, Current: true, Name: template.Name}
+			if err := tx.Create(&version); err.Error != nil {
+				return err.Error
+			}
+			if err := tx.Model(&model.AppealTemplateField{}).Where("template = ?", template.ID).Update("version", version.ID); err.Error != nil {
+				return err.Error
+			}
+			if err := tx.Model(&model.Appeal{}).Where("template = ?", template.ID).Update("template_version", version.ID); err.Error != nil {
+				return err.Error
+			}
+			return tx.Model(&template).Update("version", 1).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTemplateStatuses publishes templates created before they had a status,
+// as they were all live.
+func migrateTemplateStatuses() error {
+	return DB.Model(&model.AppealTemplate{}).Where("status = '' OR status IS NULL").Update("status", "published").Error
 }
@@ -0,0 +1,158 @@
+package domains
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/loginflow"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidDomain    = errors.New("domain is not valid")
+	ErrDomainTaken      = errors.New("domain is already in use")
+	ErrDomainNotFound   = errors.New("domain not found")
+	ErrInvalidMethod    = errors.New("verification method must be dns or http")
+	ErrChallengeMissing = errors.New("verification challenge could not be found")
+)
+
+// Subdomains of the root domain which are never organisations
+var reservedSubdomains = map[string]bool{"www": true, "api": true, "app": true}
+
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// Resolve finds the organisation served on the host, either as a subdomain of
+// the root domain or as a verified custom domain. It returns nil when the host
+// does not belong to any organisation.
+func Resolve(host string) (*model.Organisation, error) {
+	host = NormaliseHost(host)
+	rootDomain := loginflow.RootDomain()
+
+	var organisation model.Organisation
+	if strings.HasSuffix(host, "."+rootDomain) {
+		slug := strings.TrimSuffix(host, "."+rootDomain)
+		if slug == "" || strings.Contains(slug, ".") || reservedSubdomains[slug] {
+			return nil, nil
+		}
+		if result := db.DB.Find(&organisation, "url = ?", slug); result.Error != nil || result.RowsAffected == 0 {
+			return nil, result.Error
+		}
+		return &organisation, nil
+	}
+
+	if host == rootDomain || host == "localhost" || net.ParseIP(host) != nil {
+		return nil, nil
+	}
+
+	var customDomain model.CustomDomain
+	if result := db.DB.Find(&customDomain, "domain = ? AND verified_at IS NOT NULL", host); result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	if err := db.DB.First(&organisation, "Id = ?", customDomain.Organisation); err.Error != nil {
+		return nil, err.Error
+	}
+	return &organisation, nil
+}
+
+// NormaliseHost strips the port and any trailing dot from a Host header.
+func NormaliseHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// ForOrganisation returns the organisation's custom domains.
+func ForOrganisation(organisationId uuid.UUID) ([]model.CustomDomain, error) {
+	customDomains := []model.CustomDomain{}
+	if err := db.DB.Find(&customDomains, "organisation = ?", organisationId); err.Error != nil {
+		return nil, err.Error
+	}
+	return customDomains, nil
+}
+
+// Add starts setting up a custom domain, returning the challenge the organisation
+// must publish to prove they own it.
+func Add(organisationId uuid.UUID, domain string) (*model.CustomDomain, error) {
+	domain = NormaliseHost(domain)
+	rootDomain := loginflow.RootDomain()
+	if !domainPattern.MatchString(domain) || domain == rootDomain || strings.HasSuffix(domain, "."+rootDomain) {
+		return nil, ErrInvalidDomain
+	}
+
+	// Unverified claims don't block anyone else, otherwise a domain could be squatted
+	var existing int64
+	db.DB.Model(&model.CustomDomain{}).Where("domain = ? AND (verified_at IS NOT NULL OR organisation = ?)", domain, organisationId).Count(&existing)
+	if existing > 0 {
+		return nil, ErrDomainTaken
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+
+	customDomain := model.CustomDomain{
+		Organisation:      organisationId,
+		Domain:            domain,
+		VerificationToken: hex.EncodeToString(tokenBytes),
+	}
+	if err := db.DB.Create(&customDomain); err.Error != nil {
+		return nil, err.Error
+	}
+	return &customDomain, nil
+}
+
+// Verify checks the domain's challenge using the method and marks it verified
+// once the challenge is found.
+func Verify(organisationId uuid.UUID, domainId uuid.UUID, method string) (*model.CustomDomain, error) {
+	var customDomain model.CustomDomain
+	if err := db.DB.First(&customDomain, "id = ? AND organisation = ?", domainId, organisationId); err.Error != nil {
+		return nil, ErrDomainNotFound
+	}
+
+	var taken int64
+	db.DB.Model(&model.CustomDomain{}).Where("domain = ? AND id <> ? AND verified_at IS NOT NULL", customDomain.Domain, customDomain.ID).Count(&taken)
+	if taken > 0 {
+		return nil, ErrDomainTaken
+	}
+
+	verified, err := GetVerifier().Verify(method, customDomain.Domain, customDomain.VerificationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	customDomain.LastCheckedAt = &now
+	if verified {
+		customDomain.VerificationMethod = method
+		customDomain.VerifiedAt = &now
+	}
+	if err := db.DB.Save(&customDomain); err.Error != nil {
+		return nil, err.Error
+	}
+
+	if !verified {
+		return &customDomain, ErrChallengeMissing
+	}
+	return &customDomain, nil
+}
+
+// Remove stops serving the organisation on the custom domain.
+func Remove(organisationId uuid.UUID, domainId uuid.UUID) error {
+	result := db.DB.Unscoped().Delete(&model.CustomDomain{}, "id = ? AND organisation = ?", domainId, organisationId)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDomainNotFound
+	}
+	return nil
+}
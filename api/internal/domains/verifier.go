@@ -0,0 +1,156 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	challengeTimeout = 10 * time.Second
+	lookupTimeout    = 5 * time.Second
+)
+
+var errPrivateAddress = errors.New("challenge address is not public")
+
+// carrierGradeNAT is shared address space which, like private ranges, is not
+// reachable on the internet.
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Ways an organisation can prove it owns a domain
+const (
+	MethodDNS  = "dns"
+	MethodHTTP = "http"
+)
+
+// Verifier checks that the challenge for a domain has been published.
+type Verifier interface {
+	Verify(method string, domain string, token string) (bool, error)
+}
+
+var (
+	verifier Verifier
+	mutex    sync.Mutex
+)
+
+// GetVerifier returns the configured verifier. Setting DOMAIN_VERIFICATION to
+// "stub" accepts every challenge, for local development without real DNS.
+func GetVerifier() Verifier {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if verifier == nil {
+		if os.Getenv("DOMAIN_VERIFICATION") == "stub" {
+			verifier = StubVerifier{}
+		} else {
+			// Addresses are checked as they are dialed, after the domain has been
+			// resolved, so a domain cannot point the challenge at the API's network
+			dialer := &net.Dialer{Timeout: challengeTimeout, Control: requirePublicAddress}
+			verifier = ChallengeVerifier{Client: &http.Client{
+				Timeout:   challengeTimeout,
+				Transport: &http.Transport{DialContext: dialer.DialContext},
+				// The challenge must be served by the domain itself
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}}
+		}
+	}
+	return verifier
+}
+
+// SetVerifier replaces the verifier used by GetVerifier.
+func SetVerifier(v Verifier) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	verifier = v
+}
+
+// ChallengeRecord is the name of the TXT record checked by DNS verification.
+func ChallengeRecord(domain string) string {
+	return "_appealscc-challenge." + domain
+}
+
+// ChallengePath is the path served on the domain for HTTP verification.
+const ChallengePath = "/.well-known/appealscc-challenge"
+
+// ChallengeValue is what the TXT record or HTTP challenge must contain.
+func ChallengeValue(token string) string {
+	return "appealscc-verification=" + token
+}
+
+type ChallengeVerifier struct {
+	Client *http.Client
+}
+
+func (v ChallengeVerifier) Verify(method string, domain string, token string) (bool, error) {
+	expected := ChallengeValue(token)
+
+	switch method {
+	case MethodDNS:
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		defer cancel()
+
+		records, err := net.DefaultResolver.LookupTXT(ctx, ChallengeRecord(domain))
+		if err != nil {
+			if dnsError, ok := err.(*net.DNSError); ok && dnsError.IsNotFound {
+				return false, nil
+			}
+			return false, err
+		}
+		for _, record := range records {
+			if strings.TrimSpace(record) == expected {
+				return true, nil
+			}
+		}
+		return false, nil
+	case MethodHTTP:
+		response, err := v.Client.Get(fmt.Sprintf("http://%s%s", domain, ChallengePath))
+		if err != nil {
+			return false, nil
+		}
+		defer response.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(response.Body, 1024))
+		if err != nil {
+			return false, nil
+		}
+		return response.StatusCode == http.StatusOK && strings.TrimSpace(string(body)) == expected, nil
+	default:
+		return false, ErrInvalidMethod
+	}
+}
+
+// requirePublicAddress refuses to connect to loopback, private, link-local and
+// other addresses which are not reachable on the internet.
+func requirePublicAddress(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() || carrierGradeNAT.Contains(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// StubVerifier accepts every challenge without checking anything.
+type StubVerifier struct{}
+
+func (StubVerifier) Verify(method string, domain string, token string) (bool, error) {
+	if method != MethodDNS && method != MethodHTTP {
+		return false, ErrInvalidMethod
+	}
+	log.Printf("Accepting %s verification of '%s' without checking it", method, domain)
+	return true, nil
+}
@@ -0,0 +1,268 @@
+package fields
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+const (
+	OperatorAnswered    = "answered"
+	OperatorNotAnswered = "not_answered"
+	OperatorEquals      = "equals"
+	OperatorNotEquals   = "not_equals"
+	OperatorOneOf       = "one_of"
+	OperatorContains    = "contains"
+	OperatorGreaterThan = "greater_than"
+	OperatorLessThan    = "less_than"
+)
+
+// Operators lists every way a condition can compare another field's answer.
+var Operators = []string{
+	OperatorAnswered,
+	OperatorNotAnswered,
+	OperatorEquals,
+	OperatorNotEquals,
+	OperatorOneOf,
+	OperatorContains,
+	OperatorGreaterThan,
+	OperatorLessThan,
+}
+
+var keyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// State is whether a field is shown to the appellant given their other answers,
+// and whether it must be answered.
+type State struct {
+	Shown    bool
+	Required bool
+}
+
+// Evaluate works out the state of every field in the template from the answers
+// given, keyed by field ID. Answers to fields which are not shown are ignored
+// when evaluating the conditions of others.
+func Evaluate(templateFields []model.AppealTemplateField, answers map[uuid.UUID]string) map[uuid.UUID]State {
+	e := evaluator{
+		byKey:   map[string]model.AppealTemplateField{},
+		answers: answers,
+		shown:   map[string]bool{},
+		seen:    map[string]bool{},
+	}
+	for _, field := range templateFields {
+		if field.Key != "" {
+			e.byKey[field.Key] = field
+		}
+	}
+
+	states := map[uuid.UUID]State{}
+	for _, field := range templateFields {
+		shown := e.isShown(field)
+		required := field.Required || (len(field.RequireWhen) > 0 && e.holds(field.RequireWhen))
+		states[field.ID] = State{Shown: shown, Required: shown && required}
+	}
+	return states
+}
+
+type evaluator struct {
+	byKey   map[string]model.AppealTemplateField
+	answers map[uuid.UUID]string
+	shown   map[string]bool
+	seen    map[string]bool
+}
+
+func (e *evaluator) isShown(field model.AppealTemplateField) bool {
+	if len(field.ShowWhen) == 0 {
+		return true
+	}
+	if field.Key == "" {
+		return e.holds(field.ShowWhen)
+	}
+	if shown, ok := e.shown[field.Key]; ok {
+		return shown
+	}
+	// Templates are checked for loops when saved, but never recurse forever
+	if e.seen[field.Key] {
+		return false
+	}
+	e.seen[field.Key] = true
+
+	shown := e.holds(field.ShowWhen)
+	e.shown[field.Key] = shown
+	return shown
+}
+
+func (e *evaluator) holds(conditions model.FieldConditions) bool {
+	for _, condition := range conditions {
+		if !e.matches(condition) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *evaluator) matches(condition model.FieldCondition) bool {
+	field, ok := e.byKey[condition.Field]
+	if !ok {
+		return false
+	}
+
+	answer := ""
+	if e.isShown(field) {
+		answer = e.answers[field.ID]
+	}
+	answered := !IsBlank(field, answer)
+	answer = strings.TrimSpace(answer)
+
+	switch condition.Operator {
+	case OperatorAnswered:
+		return answered
+	case OperatorNotAnswered:
+		return !answered
+	case OperatorEquals:
+		return answered && answer == condition.Value
+	case OperatorNotEquals:
+		return !answered || answer != condition.Value
+	case OperatorOneOf:
+		return answered && contains(condition.Values, answer)
+	case OperatorContains:
+		if !answered {
+			return false
+		}
+		if Normalise(field.Type) == TypeCheckboxes {
+			var ticked []string
+			return json.Unmarshal([]byte(answer), &ticked) == nil && contains(ticked, condition.Value)
+		}
+		return strings.Contains(strings.ToLower(answer), strings.ToLower(condition.Value))
+	case OperatorGreaterThan, OperatorLessThan:
+		number, err := strconv.ParseFloat(answer, 64)
+		if !answered || err != nil {
+			return false
+		}
+		value, _ := strconv.ParseFloat(condition.Value, 64)
+		if condition.Operator == OperatorGreaterThan {
+			return number > value
+		}
+		return number < value
+	}
+	return false
+}
+
+// assignKeys gives fields without a key one based on their position, so that
+// they can be referred to once saved.
+func assignKeys(templateFields []model.AppealTemplateField) {
+	used := map[string]bool{}
+	for _, field := range templateFields {
+		used[field.Key] = true
+	}
+	next := 1
+	for i := range templateFields {
+		if templateFields[i].Key != "" {
+			continue
+		}
+		for used[fmt.Sprintf("field_%d", next)] {
+			next++
+		}
+		templateFields[i].Key = fmt.Sprintf("field_%d", next)
+		used[templateFields[i].Key] = true
+	}
+}
+
+// validateConditions checks every field has a unique key and that conditions
+// only refer to other fields in the template, without any loops.
+func validateConditions(templateFields []model.AppealTemplateField) map[string]string {
+	errs := map[string]string{}
+	positions := map[string]int{}
+	for i, field := range templateFields {
+		if !keyPattern.MatchString(field.Key) {
+			errs[strconv.Itoa(i)] = "Key must start with a letter and only contain lowercase letters, numbers and underscores"
+		} else if _, taken := positions[field.Key]; taken {
+			errs[strconv.Itoa(i)] = fmt.Sprintf("Key '%s' is used by more than one question", field.Key)
+		} else {
+			positions[field.Key] = i
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	for i, field := range templateFields {
+		for _, condition := range append(append(model.FieldConditions{}, field.ShowWhen...), field.RequireWhen...) {
+			if message := validateCondition(field, condition, templateFields, positions); message != "" {
+				errs[strconv.Itoa(i)] = message
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// Whether a field is shown can depend on whether the fields it refers to are
+	// shown, so those references must not loop back on themselves
+	visiting := map[string]bool{}
+	done := map[string]bool{}
+	var visit func(key string) bool
+	visit = func(key string) bool {
+		if visiting[key] {
+			return false
+		}
+		if done[key] {
+			return true
+		}
+		visiting[key] = true
+		field := templateFields[positions[key]]
+		for _, condition := range append(append(model.FieldConditions{}, field.ShowWhen...), field.RequireWhen...) {
+			if !visit(condition.Field) {
+				return false
+			}
+		}
+		visiting[key] = false
+		done[key] = true
+		return true
+	}
+	for i, field := range templateFields {
+		if !visit(field.Key) {
+			errs[strconv.Itoa(i)] = "Conditions cannot depend on each other in a loop"
+			break
+		}
+	}
+	return errs
+}
+
+func validateCondition(field model.AppealTemplateField, condition model.FieldCondition, templateFields []model.AppealTemplateField, positions map[string]int) string {
+	if condition.Field == field.Key {
+		return "A question's conditions cannot refer to itself"
+	}
+	position, ok := positions[condition.Field]
+	if !ok {
+		return fmt.Sprintf("Condition refers to a question with key '%s' which is not in the template", condition.Field)
+	}
+	if !contains(Operators, condition.Operator) {
+		return fmt.Sprintf("Condition operator must be one of %v", Operators)
+	}
+
+	referenced := templateFields[position]
+	switch condition.Operator {
+	case OperatorEquals, OperatorNotEquals, OperatorContains:
+		if condition.Value == "" {
+			return "Condition needs a value to compare with"
+		}
+		if condition.Operator != OperatorContains && HasOptions(referenced.Type) && referenced.Type != TypeCheckboxes && !contains(referenced.Options, condition.Value) {
+			return fmt.Sprintf("'%s' is not one of the options of the question it refers to", condition.Value)
+		}
+	case OperatorOneOf:
+		if len(condition.Values) == 0 {
+			return "Condition needs values to compare with"
+		}
+	case OperatorGreaterThan, OperatorLessThan:
+		if _, err := strconv.ParseFloat(condition.Value, 64); err != nil {
+			return "Condition needs a number to compare with"
+		}
+	}
+	return ""
+}
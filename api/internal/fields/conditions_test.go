@@ -0,0 +1,157 @@
+package fields
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+func field(key string, fieldType string, conditions ...model.FieldCondition) model.AppealTemplateField {
+	return model.AppealTemplateField{Base: model.Base{ID: uuid.New()}, Key: key, Title: key, Type: fieldType, ShowWhen: conditions}
+}
+
+func when(key string, operator string, value string, values ...string) model.FieldCondition {
+	return model.FieldCondition{Field: key, Operator: operator, Value: value, Values: values}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition model.FieldCondition
+		source    model.AppealTemplateField
+		answer    string
+		shown     bool
+	}{
+		{"answered", when("source", OperatorAnswered, ""), field("source", TypeShortText), "hello", true},
+		{"answered when blank", when("source", OperatorAnswered, ""), field("source", TypeShortText), "  ", false},
+		{"not answered", when("source", OperatorNotAnswered, ""), field("source", TypeShortText), "", true},
+		{"equals", when("source", OperatorEquals, "yes"), field("source", TypeYesNo), "yes", true},
+		{"equals another answer", when("source", OperatorEquals, "yes"), field("source", TypeYesNo), "no", false},
+		{"not equals when unanswered", when("source", OperatorNotEquals, "yes"), field("source", TypeYesNo), "", true},
+		{"one of", when("source", OperatorOneOf, "", "a", "b"), field("source", TypeRadio), "b", true},
+		{"one of another answer", when("source", OperatorOneOf, "", "a", "b"), field("source", TypeRadio), "c", false},
+		{"contains text", when("source", OperatorContains, "CHEAT"), field("source", TypeLongText), "I was not cheating", true},
+		{"contains ticked", when("source", OperatorContains, "b"), field("source", TypeCheckboxes), `["a","b"]`, true},
+		{"contains unticked", when("source", OperatorContains, "c"), field("source", TypeCheckboxes), `["a","b"]`, false},
+		{"greater than", when("source", OperatorGreaterThan, "17"), field("source", TypeNumber), "18", true},
+		{"greater than when not a number", when("source", OperatorGreaterThan, "17"), field("source", TypeNumber), "old", false},
+		{"less than", when("source", OperatorLessThan, "13"), field("source", TypeNumber), "18", false},
+		{"unknown field", when("missing", OperatorAnswered, ""), field("source", TypeShortText), "hello", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := field("target", TypeShortText, test.condition)
+			target.Required = true
+			states := Evaluate([]model.AppealTemplateField{test.source, target}, map[uuid.UUID]string{test.source.ID: test.answer})
+
+			if states[target.ID].Shown != test.shown {
+				t.Errorf("shown is %v, want %v", states[target.ID].Shown, test.shown)
+			}
+			if states[target.ID].Required != test.shown {
+				t.Errorf("required is %v, want %v as hidden questions are never required", states[target.ID].Required, test.shown)
+			}
+		})
+	}
+}
+
+func TestEvaluateIgnoresHiddenAnswers(t *testing.T) {
+	first := field("first", TypeYesNo)
+	second := field("second", TypeShortText, when("first", OperatorEquals, "yes"))
+	third := field("third", TypeShortText, when("second", OperatorAnswered, ""))
+	third.RequireWhen = model.FieldConditions{when("second", OperatorAnswered, "")}
+
+	states := Evaluate([]model.AppealTemplateField{first, second, third}, map[uuid.UUID]string{first.ID: "no", second.ID: "still answered"})
+	if states[second.ID].Shown {
+		t.Error("second is shown, but first was not answered yes")
+	}
+	if states[third.ID].Shown || states[third.ID].Required {
+		t.Error("third is shown or required by the answer to the hidden second question")
+	}
+}
+
+func TestValidateConditions(t *testing.T) {
+	choice := field("choice", TypeDropdown)
+	choice.Options = model.StringList{"a", "b"}
+
+	tests := []struct {
+		name   string
+		fields []model.AppealTemplateField
+		want   map[string]string
+	}{
+		{
+			name:   "valid",
+			fields: []model.AppealTemplateField{choice, field("other", TypeShortText, when("choice", OperatorEquals, "a"))},
+			want:   map[string]string{},
+		},
+		{
+			name:   "invalid key",
+			fields: []model.AppealTemplateField{field("1st", TypeShortText)},
+			want:   map[string]string{"0": "Key must start with a letter and only contain lowercase letters, numbers and underscores"},
+		},
+		{
+			name:   "duplicate key",
+			fields: []model.AppealTemplateField{field("name", TypeShortText), field("name", TypeShortText)},
+			want:   map[string]string{"1": "Key 'name' is used by more than one question"},
+		},
+		{
+			name:   "refers to itself",
+			fields: []model.AppealTemplateField{field("name", TypeShortText, when("name", OperatorAnswered, ""))},
+			want:   map[string]string{"0": "A question's conditions cannot refer to itself"},
+		},
+		{
+			name:   "refers to a missing question",
+			fields: []model.AppealTemplateField{field("name", TypeShortText, when("missing", OperatorAnswered, ""))},
+			want:   map[string]string{"0": "Condition refers to a question with key 'missing' which is not in the template"},
+		},
+		{
+			name:   "unknown operator",
+			fields: []model.AppealTemplateField{choice, field("other", TypeShortText, when("choice", "is", "a"))},
+			want:   map[string]string{"1": fmt.Sprintf("Condition operator must be one of %v", Operators)},
+		},
+		{
+			name:   "missing value",
+			fields: []model.AppealTemplateField{choice, field("other", TypeShortText, when("choice", OperatorNotEquals, ""))},
+			want:   map[string]string{"1": "Condition needs a value to compare with"},
+		},
+		{
+			name:   "value is not an option",
+			fields: []model.AppealTemplateField{choice, field("other", TypeShortText, when("choice", OperatorEquals, "c"))},
+			want:   map[string]string{"1": "'c' is not one of the options of the question it refers to"},
+		},
+		{
+			name:   "missing values",
+			fields: []model.AppealTemplateField{choice, field("other", TypeShortText, when("choice", OperatorOneOf, ""))},
+			want:   map[string]string{"1": "Condition needs values to compare with"},
+		},
+		{
+			name:   "value is not a number",
+			fields: []model.AppealTemplateField{field("age", TypeNumber), field("other", TypeShortText, when("age", OperatorLessThan, "young"))},
+			want:   map[string]string{"1": "Condition needs a number to compare with"},
+		},
+		{
+			name: "loop",
+			fields: []model.AppealTemplateField{
+				field("first", TypeShortText, when("second", OperatorAnswered, "")),
+				field("second", TypeShortText, when("first", OperatorAnswered, "")),
+			},
+			want: map[string]string{"0": "Conditions cannot depend on each other in a loop"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := validateConditions(test.fields)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for position, message := range test.want {
+				if got[position] != message {
+					t.Errorf("error for %s is %q, want %q", position, got[position], message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package fields
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+)
+
+// Question types. Key, Section, Position, Required, ShowWhen and RequireWhen
+// apply to every type, and CharacterLimit caps the length of any answer.
+const (
+	// Min and Max bound the answer's length and Pattern is a regular expression it must match
+	TypeShortText = "short_text"
+	TypeLongText  = "long_text"
+	// Min and Max bound the answer
+	TypeNumber = "number"
+	// Options lists the choices, of which exactly one is picked
+	TypeDropdown = "dropdown"
+	TypeRadio    = "radio"
+	// Options lists the choices, and Min and Max bound how many are ticked
+	TypeCheckboxes = "checkboxes"
+	// Answers are written in DateFormat
+	TypeDate              = "date"
+	TypeURL               = "url"
+	TypeEmail             = "email"
+	TypeDiscordUserID     = "discord_user_id"
+	TypeMinecraftUsername = "minecraft_username"
+	// A link to a file hosted elsewhere. Extensions lists the file types it may end in
+	TypeFileLink = "file_link"
+	TypeYesNo    = "yes_no"
+)
+
+// Types lists every kind of question a template can ask.
+var Types = []string{
+	TypeShortText,
+	TypeLongText,
+	TypeNumber,
+	TypeDropdown,
+	TypeRadio,
+	TypeCheckboxes,
+	TypeDate,
+	TypeURL,
+	TypeEmail,
+	TypeDiscordUserID,
+	TypeMinecraftUsername,
+	TypeFileLink,
+	TypeYesNo,
+}
+
+// DateFormat is how date answers are written.
+const DateFormat = "2006-01-02"
+
+const (
+	maxOptions       = 100
+	maxPatternLength = 256
+)
+
+var (
+	discordUserIDPattern     = regexp.MustCompile(`^[0-9]{17,20}$`)
+	minecraftUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,16}$`)
+)
+
+// aliases are the names templates used for types before they had a fixed set.
+var aliases = map[string]string{
+	"":          TypeShortText,
+	"text":      TypeShortText,
+	"textarea":  TypeLongText,
+	"paragraph": TypeLongText,
+	"file":      TypeFileLink,
+}
+
+// Normalise returns the type a field's type name refers to, accepting the names
+// used before types were fixed.
+func Normalise(fieldType string) string {
+	fieldType = strings.ToLower(strings.TrimSpace(fieldType))
+	if alias, ok := aliases[fieldType]; ok {
+		return alias
+	}
+	return fieldType
+}
+
+// IsType reports whether the type is one templates can use.
+func IsType(fieldType string) bool {
+	for _, t := range Types {
+		if t == fieldType {
+			return true
+		}
+	}
+	return false
+}
+
+// HasOptions reports whether fields of the type are answered by picking options.
+func HasOptions(fieldType string) bool {
+	return fieldType == TypeDropdown || fieldType == TypeRadio || fieldType == TypeCheckboxes
+}
+
+func isText(fieldType string) bool {
+	return fieldType == TypeShortText || fieldType == TypeLongText
+}
+
+// ValidateTemplate checks the sections and fields of a template, the settings of
+// every field and the conditions between them, normalising their types and
+// giving any section or field without a key one. Problems are keyed by the
+// field's position in the template, or by SectionErrorKey for sections.
+func ValidateTemplate(sections []model.AppealTemplateSection, fields []model.AppealTemplateField) map[string]string {
+	errs := map[string]string{}
+	for i := range fields {
+		if message := ValidateConfig(&fields[i]); message != "" {
+			errs[strconv.Itoa(i)] = message
+		}
+	}
+
+	assignKeys(fields)
+	assignSectionKeys(sections)
+	for _, problems := range []map[string]string{validateSections(sections, fields), validateConditions(fields)} {
+		for position, message := range problems {
+			if _, reported := errs[position]; !reported {
+				errs[position] = message
+			}
+		}
+	}
+	return errs
+}
+
+// ValidateConfig checks the field's settings make sense for its type, returning
+// what is wrong with them if they don't.
+func ValidateConfig(field *model.AppealTemplateField) string {
+	field.Type = Normalise(field.Type)
+	if !IsType(field.Type) {
+		return fmt.Sprintf("Type must be one of %v", Types)
+	}
+	if strings.TrimSpace(field.Title) == "" {
+		return "Every question needs a title"
+	}
+	if field.CharacterLimit < 0 {
+		return "Character limit cannot be negative"
+	}
+
+	if HasOptions(field.Type) {
+		if len(field.Options) == 0 {
+			return "Add at least one option to choose from"
+		}
+		if len(field.Options) > maxOptions {
+			return fmt.Sprintf("Questions can have at most %d options", maxOptions)
+		}
+		seen := map[string]bool{}
+		for _, option := range field.Options {
+			if strings.TrimSpace(option) == "" {
+				return "Options cannot be blank"
+			}
+			if seen[option] {
+				return fmt.Sprintf("Option '%s' is listed more than once", option)
+			}
+			seen[option] = true
+		}
+	} else if len(field.Options) > 0 {
+		return "Only dropdowns, radios and checkboxes have options"
+	}
+
+	if field.Type == TypeFileLink {
+		if len(field.Extensions) > maxOptions {
+			return fmt.Sprintf("File links can allow at most %d extensions", maxOptions)
+		}
+		for _, extension := range field.Extensions {
+			if strings.TrimPrefix(strings.TrimSpace(extension), ".") == "" {
+				return "Extensions cannot be blank"
+			}
+		}
+	} else if len(field.Extensions) > 0 {
+		return "Only file links have allowed extensions"
+	}
+
+	if field.Min != nil || field.Max != nil {
+		if !isText(field.Type) && field.Type != TypeNumber && field.Type != TypeCheckboxes {
+			return "Only text, number and checkbox questions have a minimum or maximum"
+		}
+		if field.Type != TypeNumber && ((field.Min != nil && *field.Min < 0) || (field.Max != nil && *field.Max < 0)) {
+			return "Minimum and maximum cannot be negative"
+		}
+		if field.Min != nil && field.Max != nil && *field.Min > *field.Max {
+			return "Minimum cannot be more than the maximum"
+		}
+	}
+
+	if field.Pattern != "" {
+		if !isText(field.Type) {
+			return "Only text questions can have a pattern"
+		}
+		if len(field.Pattern) > maxPatternLength {
+			return fmt.Sprintf("Pattern must be %d characters or fewer", maxPatternLength)
+		}
+		if _, err := regexp.Compile(field.Pattern); err != nil {
+			return "Pattern is not a valid regular expression"
+		}
+	}
+
+	return ""
+}
+
+// IsBlank reports whether the answer counts as not having been given.
+func IsBlank(field model.AppealTemplateField, content string) bool {
+	content = strings.TrimSpace(content)
+	if Normalise(field.Type) == TypeCheckboxes {
+		return content == "" || content == "[]"
+	}
+	return content == ""
+}
+
+// Check returns what is wrong with the answer to the field, or nothing if it is
+// a valid answer. Checkbox answers are a JSON array of the options ticked, yes/no
+// answers are either "yes" or "no", and file link answers are a link to the file.
+func Check(field model.AppealTemplateField, content string) string {
+	if field.CharacterLimit > 0 && utf8.RuneCountInString(content) > field.CharacterLimit {
+		return "Answer must be " + strconv.Itoa(field.CharacterLimit) + " characters or fewer"
+	}
+
+	trimmed := strings.TrimSpace(content)
+	fieldType := Normalise(field.Type)
+	switch fieldType {
+	case TypeShortText, TypeLongText:
+		if fieldType == TypeShortText && strings.ContainsAny(content, "\r\n") {
+			return "Answer must be a single line"
+		}
+		length := float64(utf8.RuneCountInString(trimmed))
+		if field.Min != nil && length < *field.Min {
+			return fmt.Sprintf("Answer must be at least %g characters", *field.Min)
+		}
+		if field.Max != nil && length > *field.Max {
+			return fmt.Sprintf("Answer must be %g characters or fewer", *field.Max)
+		}
+		if field.Pattern != "" {
+			if pattern, err := regexp.Compile(field.Pattern); err == nil && !pattern.MatchString(content) {
+				return "Answer is not in the expected format"
+			}
+		}
+	case TypeNumber:
+		number, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return "Answer must be a number"
+		}
+		if field.Min != nil && number < *field.Min {
+			return fmt.Sprintf("Answer must be at least %g", *field.Min)
+		}
+		if field.Max != nil && number > *field.Max {
+			return fmt.Sprintf("Answer must be %g or less", *field.Max)
+		}
+	case TypeDropdown, TypeRadio:
+		if !contains(field.Options, content) {
+			return "Choose one of the options"
+		}
+	case TypeCheckboxes:
+		var ticked []string
+		if err := json.Unmarshal([]byte(content), &ticked); err != nil {
+			return "Answer must be a list of the options ticked"
+		}
+		seen := map[string]bool{}
+		for _, option := range ticked {
+			if !contains(field.Options, option) {
+				return fmt.Sprintf("'%s' is not one of the options", option)
+			}
+			if seen[option] {
+				return fmt.Sprintf("'%s' is ticked more than once", option)
+			}
+			seen[option] = true
+		}
+		if field.Min != nil && float64(len(ticked)) < *field.Min {
+			return fmt.Sprintf("Tick at least %g options", *field.Min)
+		}
+		if field.Max != nil && float64(len(ticked)) > *field.Max {
+			return fmt.Sprintf("Tick at most %g options", *field.Max)
+		}
+	case TypeDate:
+		if _, err := time.Parse(DateFormat, trimmed); err != nil {
+			return "Answer must be a date such as 2021-12-31"
+		}
+	case TypeURL:
+		if !isWebLink(trimmed) {
+			return "Answer must be a link starting with http:// or https://"
+		}
+	case TypeEmail:
+		if address, err := mail.ParseAddress(trimmed); err != nil || address.Address != trimmed {
+			return "Answer must be an email address"
+		}
+	case TypeDiscordUserID:
+		if !discordUserIDPattern.MatchString(trimmed) {
+			return "Answer must be a Discord user ID, which is a 17 to 20 digit number"
+		}
+	case TypeMinecraftUsername:
+		if !minecraftUsernamePattern.MatchString(trimmed) {
+			return "Answer must be a Minecraft username of 3 to 16 letters, numbers or underscores"
+		}
+	case TypeFileLink:
+		if !isWebLink(trimmed) {
+			return "Answer must be a link to the file, starting with http:// or https://"
+		}
+		if len(field.Extensions) > 0 && !allowedExtension(field.Extensions, trimmed) {
+			return fmt.Sprintf("Link must be to a file ending in one of %v", field.Extensions)
+		}
+	case TypeYesNo:
+		if trimmed != "yes" && trimmed != "no" {
+			return "Answer must be yes or no"
+		}
+	}
+	return ""
+}
+
+func isWebLink(value string) bool {
+	parsed, err := url.ParseRequestURI(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func allowedExtension(extensions []string, link string) bool {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	extension := strings.TrimPrefix(strings.ToLower(path.Ext(parsed.Path)), ".")
+	for _, allowed := range extensions {
+		if strings.TrimPrefix(strings.ToLower(allowed), ".") == extension {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package fields
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+)
+
+// SectionErrorKey is where problems with a section are reported, alongside the
+// problems with each field which are keyed by the field's position.
+func SectionErrorKey(position int) string {
+	return "sections." + strconv.Itoa(position)
+}
+
+// validateSections checks every section has a unique key and a title, and that
+// each field is in one of them. Fields can only be left out of a section when
+// the template has none.
+func validateSections(sections []model.AppealTemplateSection, templateFields []model.AppealTemplateField) map[string]string {
+	errs := map[string]string{}
+	keys := map[string]bool{}
+	for i, section := range sections {
+		if !keyPattern.MatchString(section.Key) {
+			errs[SectionErrorKey(i)] = "Key must start with a letter and only contain lowercase letters, numbers and underscores"
+		} else if keys[section.Key] {
+			errs[SectionErrorKey(i)] = fmt.Sprintf("Key '%s' is used by more than one section", section.Key)
+		} else if strings.TrimSpace(section.Title) == "" {
+			errs[SectionErrorKey(i)] = "Every section needs a title"
+		}
+		keys[section.Key] = true
+	}
+
+	for i, field := range templateFields {
+		if field.Section == "" && len(sections) > 0 {
+			errs[strconv.Itoa(i)] = "Choose which section this question is in"
+		} else if field.Section != "" && !keys[field.Section] {
+			errs[strconv.Itoa(i)] = fmt.Sprintf("Question is in a section with key '%s' which is not in the template", field.Section)
+		}
+	}
+	return errs
+}
+
+// assignSectionKeys gives sections without a key one based on their position.
+func assignSectionKeys(sections []model.AppealTemplateSection) {
+	used := map[string]bool{}
+	for _, section := range sections {
+		used[section.Key] = true
+	}
+	next := 1
+	for i := range sections {
+		if sections[i].Key != "" {
+			continue
+		}
+		for used[fmt.Sprintf("section_%d", next)] {
+			next++
+		}
+		sections[i].Key = fmt.Sprintf("section_%d", next)
+		used[sections[i].Key] = true
+	}
+}
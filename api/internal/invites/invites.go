@@ -0,0 +1,237 @@
+package invites
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/authentication"
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/mailer"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/principal"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const inviteLifetime = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidRecipient = errors.New("invite must be sent to either an email address or a Discord username")
+	ErrInviteNotFound   = errors.New("invite is invalid, has expired or has already been used")
+	ErrWrongRecipient   = errors.New("invite was sent to someone else")
+	ErrAlreadyInvited   = errors.New("recipient already has a pending invite")
+)
+
+// Create invites someone to the organisation with a role, emailing them when the
+// invite is sent to an email address. The returned link is only available now and
+// can be shared with the recipient however the inviter likes.
+func Create(organisation model.Organisation, inviter *principal.Principal, email string, discordUsername string, role string) (string, *model.OrganisationInvite, error) {
+	email = strings.TrimSpace(email)
+	discordUsername = strings.TrimSpace(discordUsername)
+	if (email == "") == (discordUsername == "") {
+		return "", nil, ErrInvalidRecipient
+	}
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return "", nil, ErrInvalidRecipient
+		}
+	}
+
+	if role == "" {
+		role = rbac.RoleModerator
+	}
+	if err := rbac.CheckAssignable(organisation.ID, role, inviter); err != nil {
+		return "", nil, err
+	}
+
+	query := pending().Where("organisation = ?", organisation.ID)
+	if email != "" {
+		query = query.Where("email = ?", email)
+	} else {
+		query = query.Where("discord_username = ?", discordUsername)
+	}
+	var existing int64
+	if err := query.Model(&model.OrganisationInvite{}).Count(&existing); err.Error != nil {
+		return "", nil, err.Error
+	}
+	if existing > 0 {
+		return "", nil, ErrAlreadyInvited
+	}
+
+	token, hash, err := authentication.GenerateSecureToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	invite := model.OrganisationInvite{
+		Organisation: organisation.ID,
+		InvitedBy:    inviter.UserID,
+		Role:         role,
+		TokenHash:    hash,
+		ExpiresAt:    time.Now().Add(inviteLifetime),
+	}
+	if email != "" {
+		invite.Email = &email
+	} else {
+		invite.DiscordUsername = &discordUsername
+	}
+	if err := db.DB.Create(&invite); err.Error != nil {
+		return "", nil, err.Error
+	}
+
+	link := authentication.FrontendLink("/invite", token)
+	if invite.Email != nil {
+		if err := mailer.Send(email, fmt.Sprintf("You've been invited to help run %s on AppealsCC", organisation.Name), fmt.Sprintf(
+			"You've been invited to join the team running %s on AppealsCC.\n\nAccept the invite by visiting the link below within 7 days:\n\n%s\n\nIf you weren't expecting this, you can ignore this email.",
+			organisation.Name, link,
+		)); err != nil {
+			return link, &invite, err
+		}
+	}
+
+	return link, &invite, nil
+}
+
+// Pending returns the organisation's invites which can still be accepted.
+func Pending(organisationId uuid.UUID) ([]model.OrganisationInvite, error) {
+	invites := []model.OrganisationInvite{}
+	if err := pending().Order("created_at desc").Find(&invites, "organisation = ?", organisationId); err.Error != nil {
+		return nil, err.Error
+	}
+	return invites, nil
+}
+
+// ForUser returns the pending invites sent to the user's verified email address
+// or to the username of a Discord account they have linked.
+func ForUser(userId uuid.UUID) ([]model.OrganisationInvite, error) {
+	invites := []model.OrganisationInvite{}
+
+	var user model.User
+	if err := db.DB.Preload("ExternalIdentities").First(&user, "Id = ?", userId); err.Error != nil {
+		return nil, err.Error
+	}
+
+	conditions := db.DB.Where("1 = 0")
+	if user.Email != nil && user.EmailVerified {
+		conditions = conditions.Or("email = ?", *user.Email)
+	}
+	for _, identity := range user.ExternalIdentities {
+		if identity.Provider == "discord" && identity.Username != "" {
+			conditions = conditions.Or("discord_username = ?", identity.Username)
+		}
+	}
+
+	if err := pending().Where(conditions).Order("created_at desc").Find(&invites); err.Error != nil {
+		return nil, err.Error
+	}
+	return invites, nil
+}
+
+// FindByToken returns the pending invite for the token from an invite link.
+func FindByToken(token string) (*model.OrganisationInvite, error) {
+	var invite model.OrganisationInvite
+	if err := pending().First(&invite, "token_hash = ?", authentication.HashToken(token)); err.Error != nil {
+		return nil, ErrInviteNotFound
+	}
+	return &invite, nil
+}
+
+// FindById returns the pending invite with the ID.
+func FindById(inviteId uuid.UUID) (*model.OrganisationInvite, error) {
+	var invite model.OrganisationInvite
+	if err := pending().First(&invite, "id = ?", inviteId); err.Error != nil {
+		return nil, ErrInviteNotFound
+	}
+	return &invite, nil
+}
+
+// Accept gives the user the role they were invited with, provided the invite was
+// sent to them.
+func Accept(invite *model.OrganisationInvite, userId uuid.UUID) (*model.OrganisationMember, error) {
+	if err := checkRecipient(invite, userId); err != nil {
+		return nil, err
+	}
+
+	var organisation model.Organisation
+	if err := db.DB.First(&organisation, "Id = ?", invite.Organisation); err.Error != nil {
+		return nil, ErrInviteNotFound
+	}
+
+	var member *model.OrganisationMember
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&model.OrganisationInvite{}).
+			Where("id = ? AND accepted_at IS NULL AND declined_at IS NULL AND revoked_at IS NULL", invite.ID).
+			Updates(map[string]interface{}{"accepted_at": now, "accepted_by": userId})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInviteNotFound
+		}
+
+		var err error
+		member, err = rbac.AddMember(tx, organisation, userId, invite.Role)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// Decline turns down an invite sent to the user.
+func Decline(invite *model.OrganisationInvite, userId uuid.UUID) error {
+	if err := checkRecipient(invite, userId); err != nil {
+		return err
+	}
+	return markUsed(db.DB.Where("id = ?", invite.ID), "declined_at")
+}
+
+// Revoke cancels a pending invite from the organisation.
+func Revoke(organisationId uuid.UUID, inviteId uuid.UUID) error {
+	return markUsed(db.DB.Where("id = ? AND organisation = ?", inviteId, organisationId), "revoked_at")
+}
+
+func markUsed(query *gorm.DB, column string) error {
+	result := query.Model(&model.OrganisationInvite{}).
+		Where("accepted_at IS NULL AND declined_at IS NULL AND revoked_at IS NULL").
+		Update(column, time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+func checkRecipient(invite *model.OrganisationInvite, userId uuid.UUID) error {
+	var user model.User
+	if err := db.DB.Preload("ExternalIdentities").First(&user, "Id = ?", userId); err.Error != nil {
+		return ErrWrongRecipient
+	}
+
+	if invite.Email != nil {
+		if user.Email != nil && user.EmailVerified && strings.EqualFold(*user.Email, *invite.Email) {
+			return nil
+		}
+		return ErrWrongRecipient
+	}
+
+	for _, identity := range user.ExternalIdentities {
+		if identity.Provider == "discord" && invite.DiscordUsername != nil && strings.EqualFold(identity.Username, *invite.DiscordUsername) {
+			return nil
+		}
+	}
+	return ErrWrongRecipient
+}
+
+func pending() *gorm.DB {
+	return db.DB.Where("accepted_at IS NULL AND declined_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now())
+}
@@ -0,0 +1,290 @@
+package lifecycle
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	StatusSubmitted         = "submitted"
+	StatusUnderReview       = "under_review"
+	StatusAwaitingAppellant = "awaiting_appellant"
+	StatusApproved          = "approved"
+	StatusDenied            = "denied"
+	StatusWithdrawn         = "withdrawn"
+	StatusExpired           = "expired"
+)
+
+// Statuses lists every status an appeal can be in, in the order they are
+// usually reached.
+var Statuses = []string{
+	StatusSubmitted,
+	StatusUnderReview,
+	StatusAwaitingAppellant,
+	StatusApproved,
+	StatusDenied,
+	StatusWithdrawn,
+	StatusExpired,
+}
+
+// OpenStatuses are those of appeals which are still waiting on someone.
+var OpenStatuses = []string{StatusSubmitted, StatusUnderReview, StatusAwaitingAppellant}
+
+// ModeratorStatuses are those of appeals which are waiting on a moderator.
+var ModeratorStatuses = []string{StatusSubmitted, StatusUnderReview}
+
+const (
+	// ActorModerator is anyone who can respond to appeals in the organisation
+	ActorModerator = "moderator"
+	// ActorAppellant is the user who submitted the appeal
+	ActorAppellant = "appellant"
+	// ActorSystem is the API itself, such as when an appeal expires
+	ActorSystem = "system"
+)
+
+// Transitions maps each status to the statuses it can move to, and who may
+// move it there.
+var Transitions = map[string]map[string][]string{
+	StatusSubmitted: {
+		StatusUnderReview:       {ActorModerator},
+		StatusAwaitingAppellant: {ActorModerator},
+		StatusApproved:          {ActorModerator},
+		StatusDenied:            {ActorModerator},
+		StatusWithdrawn:         {ActorAppellant},
+	},
+	StatusUnderReview: {
+		StatusAwaitingAppellant: {ActorModerator},
+		StatusApproved:          {ActorModerator},
+		StatusDenied:            {ActorModerator},
+		StatusWithdrawn:         {ActorAppellant},
+	},
+	StatusAwaitingAppellant: {
+		StatusUnderReview: {ActorModerator, ActorAppellant},
+		StatusApproved:    {ActorModerator},
+		StatusDenied:      {ActorModerator},
+		StatusWithdrawn:   {ActorAppellant},
+		StatusExpired:     {ActorSystem},
+	},
+	// Decisions can be revisited, but withdrawn and expired appeals are final
+	StatusApproved: {
+		StatusUnderReview: {ActorModerator},
+	},
+	StatusDenied: {
+		StatusUnderReview: {ActorModerator},
+	},
+	StatusWithdrawn: {},
+	StatusExpired:   {},
+}
+
+const (
+	expiryCheck   = time.Hour
+	defaultExpiry = 14 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidStatus     = errors.New("status is not recognised")
+	ErrInvalidTransition = errors.New("the appeal cannot move to that status from its current one")
+	ErrNotAllowed        = errors.New("you cannot move the appeal to that status")
+	ErrStatusChanged     = errors.New("the appeal's status was changed by someone else")
+)
+
+// IsStatus reports whether the status is one appeals can be in.
+func IsStatus(status string) bool {
+	_, ok := Transitions[status]
+	return ok
+}
+
+// Allowed returns the statuses the actor can move an appeal in the status to.
+func Allowed(status string, actorType string) []string {
+	allowed := []string{}
+	for _, next := range Statuses {
+		if contains(Transitions[status][next], actorType) {
+			allowed = append(allowed, next)
+		}
+	}
+	return allowed
+}
+
+// CanPerform reports whether the actor can move an appeal between the statuses.
+func CanPerform(from string, to string, actorType string) bool {
+	return check(from, to, actorType) == nil
+}
+
+// Submit creates the appeal as newly submitted by its creator.
+func Submit(appeal *model.Appeal) error {
+	appeal.Status = StatusSubmitted
+	appeal.StatusChangedAt = time.Now()
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(appeal); err.Error != nil {
+			return err.Error
+		}
+		return tx.Create(&model.AppealTransition{
+			Appeal:    appeal.ID,
+			ToStatus:  StatusSubmitted,
+			ActorType: ActorAppellant,
+			Actor:     &appeal.Creator,
+		}).Error
+	})
+}
+
+// Transition moves the appeal to a new status and records who did it. Actor is
+// nil when actorType is ActorSystem.
+func Transition(appeal *model.Appeal, to string, actorType string, actor *uuid.UUID, reason string) (*model.AppealTransition, error) {
+	var transition *model.AppealTransition
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transition, err = move(tx, appeal, to, actorType, actor, nil, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transition, nil
+}
+
+// Respond adds a moderator's response to the appeal, moving it to the status
+// given along with it. A response without a status on a newly submitted appeal
+// puts it under review.
+func Respond(appeal *model.Appeal, author uuid.UUID, content string, status string) (*model.AppealResponse, error) {
+	if status == "" && appeal.Status == StatusSubmitted {
+		status = StatusUnderReview
+	}
+	if status == appeal.Status {
+		status = ""
+	}
+	if status != "" {
+		if err := check(appeal.Status, status, ActorModerator); err != nil {
+			return nil, err
+		}
+	}
+
+	response := model.AppealResponse{Appeal: appeal.ID, Author: author, Content: content}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&response); err.Error != nil {
+			return err.Error
+		}
+		if err := tx.Model(appeal).Update("responded", true); err.Error != nil {
+			return err.Error
+		}
+		if status != "" {
+			if _, err := move(tx, appeal, status, ActorModerator, &author, &response.ID, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// History returns every status change of the appeal, oldest first.
+func History(appealId uuid.UUID) ([]model.AppealTransition, error) {
+	transitions := []model.AppealTransition{}
+	if err := db.DB.Order("created_at asc").Find(&transitions, "appeal = ?", appealId); err.Error != nil {
+		return nil, err.Error
+	}
+	return transitions, nil
+}
+
+// ExpireStale expires appeals which have been waiting on their appellant for
+// longer than APPEAL_EXPIRY_DAYS. Edits that leave the status alone, such as
+// assigning or tagging the appeal, do not restart the wait.
+func ExpireStale() error {
+	appeals := []model.Appeal{}
+	if err := db.DB.Find(&appeals, "status = ? AND status_changed_at < ?", StatusAwaitingAppellant, time.Now().Add(-expiry())); err.Error != nil {
+		return err.Error
+	}
+
+	for i := range appeals {
+		if _, err := Transition(&appeals[i], StatusExpired, ActorSystem, nil, "The appellant did not reply in time"); err != nil && err != ErrStatusChanged {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartExpiry periodically expires appeals left waiting on their appellant.
+func StartExpiry() {
+	ticker := time.NewTicker(expiryCheck)
+	go func() {
+		for range ticker.C {
+			if err := ExpireStale(); err != nil {
+				sentry.CaptureException(err)
+			}
+		}
+	}()
+}
+
+func move(tx *gorm.DB, appeal *model.Appeal, to string, actorType string, actor *uuid.UUID, response *uuid.UUID, reason string) (*model.AppealTransition, error) {
+	if err := check(appeal.Status, to, actorType); err != nil {
+		return nil, err
+	}
+
+	// Only move the appeal on from the status it was read in, so two moderators
+	// deciding at once cannot both succeed
+	now := time.Now()
+	result := tx.Model(&model.Appeal{}).Where("id = ? AND status = ?", appeal.ID, appeal.Status).Updates(map[string]interface{}{"status": to, "status_changed_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrStatusChanged
+	}
+
+	transition := model.AppealTransition{
+		Appeal:     appeal.ID,
+		FromStatus: appeal.Status,
+		ToStatus:   to,
+		ActorType:  actorType,
+		Actor:      actor,
+		Response:   response,
+		Reason:     reason,
+	}
+	if err := tx.Create(&transition); err.Error != nil {
+		return nil, err.Error
+	}
+
+	appeal.Status = to
+	appeal.StatusChangedAt = now
+	return &transition, nil
+}
+
+func check(from string, to string, actorType string) error {
+	if !IsStatus(to) {
+		return ErrInvalidStatus
+	}
+	actors, ok := Transitions[from][to]
+	if !ok {
+		return ErrInvalidTransition
+	}
+	if !contains(actors, actorType) {
+		return ErrNotAllowed
+	}
+	return nil
+}
+
+func expiry() time.Duration {
+	if days, err := strconv.Atoi(os.Getenv("APPEAL_EXPIRY_DAYS")); err == nil && days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return defaultExpiry
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package loginflow
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/authentication"
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+const (
+	attemptLifetime = 10 * time.Minute
+	stateCookieName = "appealscc_oauth_state"
+	stateCookiePath = "/api/auth"
+)
+
+var (
+	ErrInvalidState    = errors.New("login state is missing, invalid or has expired")
+	ErrInvalidRedirect = errors.New("redirect_to is not an appeals.cc address")
+)
+
+// Begin starts a new login attempt for the provider, remembering the state,
+// PKCE verifier and post-login redirect, and returns the URL to send the user to.
+func Begin(w http.ResponseWriter, r *http.Request, provider string, config *oauth2.Config) (string, error) {
+	return begin(w, r, provider, config, nil)
+}
+
+// BeginLink starts an attempt that attaches the provider's identity to an
+// already logged in user rather than logging in with it.
+func BeginLink(w http.ResponseWriter, r *http.Request, provider string, config *oauth2.Config, userId uuid.UUID) (string, error) {
+	return begin(w, r, provider, config, &userId)
+}
+
+func begin(w http.ResponseWriter, r *http.Request, provider string, config *oauth2.Config, userId *uuid.UUID) (string, error) {
+	redirectTo, err := ValidateRedirect(r.URL.Query().Get("redirect_to"))
+	if err != nil {
+		return "", err
+	}
+
+	state, stateHash, err := authentication.GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, _, err := authentication.GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+
+	db.DB.Unscoped().Where("expires_at < ?", time.Now()).Delete(&model.LoginAttempt{})
+
+	attempt := model.LoginAttempt{
+		StateHash:    stateHash,
+		Provider:     provider,
+		User:         userId,
+		CodeVerifier: verifier,
+		RedirectTo:   redirectTo,
+		ExpiresAt:    time.Now().Add(attemptLifetime),
+	}
+	if err := db.DB.Create(&attempt); err.Error != nil {
+		return "", err.Error
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     stateCookiePath,
+		MaxAge:   int(attemptLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	return config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+// Complete validates the state returned to the callback against the cookie set
+// by Begin and consumes the matching login attempt, so it can only be used once.
+func Complete(w http.ResponseWriter, r *http.Request, provider string) (*model.LoginAttempt, error) {
+	state := r.FormValue("state")
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Path:     stateCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return nil, ErrInvalidState
+	}
+
+	var attempt model.LoginAttempt
+	if err := db.DB.First(&attempt, "state_hash = ? AND provider = ?", authentication.HashToken(state), provider); err.Error != nil {
+		return nil, ErrInvalidState
+	}
+
+	if result := db.DB.Unscoped().Delete(&model.LoginAttempt{}, "id = ?", attempt.ID); result.Error != nil || result.RowsAffected == 0 {
+		return nil, ErrInvalidState
+	}
+
+	if time.Now().After(attempt.ExpiresAt) {
+		return nil, ErrInvalidState
+	}
+
+	return &attempt, nil
+}
+
+// ExchangeOptions returns the options needed to redeem the authorisation code
+// for the given attempt.
+func ExchangeOptions(attempt *model.LoginAttempt) []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_verifier", attempt.CodeVerifier)}
+}
+
+// ValidateRedirect only allows redirects back to the appeals.cc frontend or one
+// of its organisation subdomains.
+func ValidateRedirect(redirectTo string) (string, error) {
+	if redirectTo == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(redirectTo)
+	if err != nil || parsed.User != nil {
+		return "", ErrInvalidRedirect
+	}
+
+	rootDomain := RootDomain()
+	host := strings.ToLower(parsed.Hostname())
+	if host != rootDomain && !strings.HasSuffix(host, "."+rootDomain) {
+		return "", ErrInvalidRedirect
+	}
+
+	if parsed.Scheme != "https" && !(parsed.Scheme == "http" && rootDomain == "localhost") {
+		return "", ErrInvalidRedirect
+	}
+
+	return parsed.String(), nil
+}
+
+func RootDomain() string {
+	if domain := os.Getenv("ROOT_DOMAIN"); domain != "" {
+		return strings.ToLower(domain)
+	}
+	return "appeals.cc"
+}
+
+// RedirectWithTokens sends the user back to the page they started logging in
+// from, passing the issued tokens in the URL fragment so they never reach a server.
+func RedirectWithTokens(w http.ResponseWriter, r *http.Request, attempt *model.LoginAttempt, values url.Values) {
+	target, _ := url.Parse(attempt.RedirectTo)
+	target.Fragment = ""
+	target.RawFragment = ""
+	http.Redirect(w, r, target.String()+"#"+values.Encode(), http.StatusFound)
+}
@@ -0,0 +1,47 @@
+package loginflow
+
+import "testing"
+
+func TestValidateRedirect(t *testing.T) {
+	tests := []struct {
+		name       string
+		rootDomain string
+		redirectTo string
+		want       string
+		valid      bool
+	}{
+		{"none", "", "", "", true},
+		{"root domain", "", "https://appeals.cc/dashboard", "https://appeals.cc/dashboard", true},
+		{"organisation subdomain", "", "https://hypixel.appeals.cc/appeals?status=open", "https://hypixel.appeals.cc/appeals?status=open", true},
+		{"uppercase host", "", "https://Hypixel.APPEALS.cc/", "https://Hypixel.APPEALS.cc/", true},
+		{"another domain", "", "https://example.com/", "", false},
+		{"domain ending with the root domain", "", "https://evilappeals.cc/", "", false},
+		{"root domain as a subdomain", "", "https://appeals.cc.example.com/", "", false},
+		{"credentials", "", "https://appeals.cc@example.com/", "", false},
+		{"http", "", "http://appeals.cc/", "", false},
+		{"javascript", "", "javascript:alert(1)", "", false},
+		{"relative", "", "/dashboard", "", false},
+		{"protocol relative", "", "//example.com/", "", false},
+		{"not a url", "", "https://appeals.cc/%zz", "", false},
+		{"http on localhost", "localhost", "http://localhost:3000/", "http://localhost:3000/", true},
+		{"configured root domain", "Appeals.Test", "https://org.appeals.test/", "https://org.appeals.test/", true},
+		{"default root domain when configured", "appeals.test", "https://appeals.cc/", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("ROOT_DOMAIN", test.rootDomain)
+
+			got, err := ValidateRedirect(test.redirectTo)
+			if test.valid && err != nil {
+				t.Fatalf("error is %v, want none", err)
+			}
+			if !test.valid && err != ErrInvalidRedirect {
+				t.Fatalf("error is %v, want %v", err, ErrInvalidRedirect)
+			}
+			if got != test.want {
+				t.Errorf("redirect is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
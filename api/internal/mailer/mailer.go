@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Mailer sends plain text emails to users.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+var (
+	mailer Mailer
+	mutex  sync.Mutex
+)
+
+// Get returns the configured mailer, using SMTP when SMTP_HOST is set and
+// falling back to logging emails to stdout for local development. Logged emails
+// only include their bodies, which carry single-use tokens, when
+// MAIL_LOG_BODIES is true.
+func Get() Mailer {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if mailer == nil {
+		if os.Getenv("SMTP_HOST") != "" {
+			mailer = SMTPMailer{
+				Host:     os.Getenv("SMTP_HOST"),
+				Port:     os.Getenv("SMTP_PORT"),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				From:     os.Getenv("SMTP_FROM"),
+			}
+		} else {
+			mailer = LogMailer{ShowBodies: os.Getenv("MAIL_LOG_BODIES") == "true"}
+		}
+	}
+	return mailer
+}
+
+// Set replaces the mailer used by Get.
+func Set(m Mailer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	mailer = m
+}
+
+func Send(to string, subject string, body string) error {
+	return Get().Send(to, subject, body)
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return errors.New("email headers cannot contain line breaks")
+	}
+
+	port := m.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(m.Host+":"+port, auth, m.From, []string{to}, []byte(message))
+}
+
+type LogMailer struct {
+	ShowBodies bool
+}
+
+func (m LogMailer) Send(to string, subject string, body string) error {
+	if m.ShowBodies {
+		fmt.Printf("Email to %s - %s\n%s\n", to, subject, body)
+	} else {
+		fmt.Printf("Email to %s - %s\n", to, subject)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package minecraft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/benhall-1/appealscc/api/internal/config"
+	"github.com/benhall-1/appealscc/api/internal/models/minecraftmodel"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNoXboxAccount = errors.New("the microsoft account does not have an xbox account")
+	ErrChildAccount  = errors.New("the microsoft account is a child account and must be added to a family")
+	ErrNoMinecraft   = errors.New("the microsoft account does not own minecraft java edition")
+)
+
+// Xbox Live error codes returned by XSTS when the account cannot be used
+const (
+	xErrNoXboxAccount = 2148916233
+	xErrChildAccount  = 2148916238
+)
+
+// GetProfile walks the Microsoft -> Xbox Live -> XSTS -> Minecraft services chain
+// and returns the Minecraft Java profile owned by the Microsoft account.
+func GetProfile(ctx context.Context, token *oauth2.Token) (*minecraftmodel.MinecraftProfile, error) {
+	var xbox minecraftmodel.XboxAuthResponse
+	if err := postJSON(ctx, config.EnvOrDefault("XBOX_AUTH_URL", "https://user.auth.xboxlive.com/user/authenticate"), minecraftmodel.XboxAuthRequest{
+		Properties: minecraftmodel.XboxAuthProperties{
+			AuthMethod: "RPS",
+			SiteName:   "user.auth.xboxlive.com",
+			RpsTicket:  "d=" + token.AccessToken,
+		},
+		RelyingParty: "http://auth.xboxlive.com",
+		TokenType:    "JWT",
+	}, &xbox); err != nil {
+		return nil, err
+	}
+
+	var xsts minecraftmodel.XboxAuthResponse
+	if err := postJSON(ctx, config.EnvOrDefault("XSTS_AUTH_URL", "https://xsts.auth.xboxlive.com/xsts/authorize"), minecraftmodel.XboxAuthRequest{
+		Properties: minecraftmodel.XboxAuthProperties{
+			SandboxId:  "RETAIL",
+			UserTokens: []string{xbox.Token},
+		},
+		RelyingParty: "rp://api.minecraftservices.com/",
+		TokenType:    "JWT",
+	}, &xsts); err != nil {
+		return nil, err
+	}
+	if len(xsts.DisplayClaims.Xui) == 0 {
+		return nil, errors.New("xsts response did not include a user hash")
+	}
+
+	minecraftURL := strings.TrimSuffix(config.EnvOrDefault("MINECRAFT_API_URL", "https://api.minecraftservices.com"), "/")
+
+	var login minecraftmodel.MinecraftLoginResponse
+	if err := postJSON(ctx, minecraftURL+"/authentication/login_with_xbox", minecraftmodel.MinecraftLoginRequest{
+		IdentityToken: fmt.Sprintf("XBL3.0 x=%s;%s", xsts.DisplayClaims.Xui[0].Uhs, xsts.Token),
+	}, &login); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, minecraftURL+"/minecraft/profile", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+login.Access_token)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNoMinecraft
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("minecraft services returned %s", res.Status)
+	}
+
+	var profile minecraftmodel.MinecraftProfile
+	if err := json.NewDecoder(res.Body).Decode(&profile); err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+func postJSON(ctx context.Context, url string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		var xboxError minecraftmodel.XboxErrorResponse
+		json.NewDecoder(res.Body).Decode(&xboxError)
+		switch xboxError.XErr {
+		case xErrNoXboxAccount:
+			return ErrNoXboxAccount
+		case xErrChildAccount:
+			return ErrChildAccount
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,51 @@
+package appealmodel
+
+import (
+	"github.com/benhall-1/appealscc/api/internal/answers"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+// ValidationErrorResponse explains why a submitted appeal was rejected, keyed by
+// the ID of each field with a problem.
+type ValidationErrorResponse struct {
+	Message string         `json:"message"`
+	Errors  answers.Errors `json:"errors"`
+}
+
+type ResponseRequest struct {
+	Content string `json:"content"`
+	// Status optionally moves the appeal on, such as to approved or denied
+	Status string `json:"status"`
+}
+
+type TransitionRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// HistoryResponse is an appeal's current status, every change that led to it
+// and the statuses the caller can move it to next.
+type HistoryResponse struct {
+	Status      string                   `json:"status"`
+	Allowed     []string                 `json:"allowed"`
+	Transitions []model.AppealTransition `json:"transitions"`
+}
+
+// AppealPage is one page of an organisation's appeals. Total is how many appeals
+// match the filters across every page, and NextCursor fetches the page after
+// this one, which is empty on the last page.
+type AppealPage struct {
+	Appeals    []model.Appeal `json:"appeals"`
+	Total      int64          `json:"total"`
+	NextCursor string         `json:"nextCursor"`
+}
+
+type AssignRequest struct {
+	// Moderator is left empty to unassign the appeal
+	Moderator *uuid.UUID `json:"moderator"`
+}
+
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
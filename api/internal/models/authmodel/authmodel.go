@@ -3,7 +3,9 @@ package authmodel
 import (
 	"time"
 
+	"github.com/benhall-1/appealscc/api/internal/models/model"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 )
 
 type LoginRequest struct {
@@ -11,15 +13,84 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type EmailTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
 type Claims struct {
 	Id          string `json:"Id"`
 	Email       string `json:"Email"`
 	GlobalAdmin bool   `json:"GlobalAdmin"`
 	PremiumType int    `json:"PremiumType"`
+	TwoFactor   bool   `json:"TwoFactor"`
 	jwt.StandardClaims
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
 type TokenResponse struct {
-	Token      string    `json:"token"`
-	Expiration time.Time `json:"expiration"`
+	Token             string    `json:"token"`
+	Expiration        time.Time `json:"expiration"`
+	RefreshToken      string    `json:"refreshToken"`
+	RefreshExpiration time.Time `json:"refreshExpiration"`
+}
+
+type AuthorizeURLResponse struct {
+	Url string `json:"url"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool      `json:"twoFactorRequired"`
+	ChallengeToken    string    `json:"challengeToken"`
+	Expiration        time.Time `json:"expiration"`
+}
+
+type TwoFactorEnrolmentResponse struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type TwoFactorRequirementRequest struct {
+	RequireTwoFactor bool `json:"requireTwoFactor"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name         string     `json:"name"`
+	Organisation uuid.UUID  `json:"organisation"`
+	Scopes       []string   `json:"scopes"`
+	ExpiresAt    *time.Time `json:"expiresAt"`
+}
+
+type CreateAPIKeyResponse struct {
+	Key    string       `json:"key"`
+	APIKey model.APIKey `json:"apiKey"`
 }
@@ -0,0 +1,49 @@
+package minecraftmodel
+
+type XboxAuthRequest struct {
+	Properties   XboxAuthProperties `json:"Properties"`
+	RelyingParty string             `json:"RelyingParty"`
+	TokenType    string             `json:"TokenType"`
+}
+
+type XboxAuthProperties struct {
+	AuthMethod string   `json:"AuthMethod,omitempty"`
+	SiteName   string   `json:"SiteName,omitempty"`
+	RpsTicket  string   `json:"RpsTicket,omitempty"`
+	SandboxId  string   `json:"SandboxId,omitempty"`
+	UserTokens []string `json:"UserTokens,omitempty"`
+}
+
+type XboxAuthResponse struct {
+	IssueInstant  string `json:"IssueInstant"`
+	NotAfter      string `json:"NotAfter"`
+	Token         string `json:"Token"`
+	DisplayClaims struct {
+		Xui []struct {
+			Uhs string `json:"uhs"`
+		} `json:"xui"`
+	} `json:"DisplayClaims"`
+}
+
+type XboxErrorResponse struct {
+	Identity string `json:"Identity"`
+	XErr     int64  `json:"XErr"`
+	Message  string `json:"Message"`
+	Redirect string `json:"Redirect"`
+}
+
+type MinecraftLoginRequest struct {
+	IdentityToken string `json:"identityToken"`
+}
+
+type MinecraftLoginResponse struct {
+	Username     string `json:"username"`
+	Access_token string `json:"access_token"`
+	Token_type   string `json:"token_type"`
+	Expires_in   int    `json:"expires_in"`
+}
+
+type MinecraftProfile struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
@@ -1,7 +1,10 @@
 package model
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -10,66 +13,223 @@ import (
 
 type User struct {
 	Base
-	Email              string           `json:"Email" gorm:"uniqueIndex;type:varchar(256);"`
-	Password           string           `json:"-"`
-	OrganisationMods   []*Organisation  `json:"Organisations" gorm:"many2many:organisation_moderators;"`
-	OwnedOrganisations []*Organisation  `json:"OwnedOrganisations" gorm:"foreignKey:OwnerID;references:ID;constraint:OnDelete:RESTRICT"`
-	GlobalAdmin        bool             `json:"GlobalAdmin" gorm:"default:false;"`
-	Appeals            []Appeal         `json:"Appeal" gorm:"foreignKey:Creator;references:ID;constraint:OnDelete:CASCADE"`
-	AppealResponses    []AppealResponse `json:"AppealResponses" gorm:"foreignKey:Author;references:ID;constraint:OnDelete:CASCADE"`
-	PremiumType        int              `json:"PremiumType"  gorm:"type:tinyint;default:0;"`
+	Email              *string              `json:"Email" gorm:"uniqueIndex;type:varchar(256);"`
+	EmailVerified      bool                 `json:"EmailVerified" gorm:"default:false;"`
+	Password           string               `json:"-"`
+	TwoFactorEnabled   bool                 `json:"TwoFactorEnabled" gorm:"default:false;"`
+	TwoFactorSecret    string               `json:"-"`
+	TwoFactorLastStep  int64                `json:"-"`
+	RecoveryCodes      []RecoveryCode       `json:"-" gorm:"foreignKey:User;references:ID;constraint:OnDelete:CASCADE"`
+	Memberships        []OrganisationMember `json:"Memberships" gorm:"foreignKey:User;references:ID;constraint:OnDelete:CASCADE"`
+	OwnedOrganisations []*Organisation      `json:"OwnedOrganisations" gorm:"foreignKey:OwnerID;references:ID;constraint:OnDelete:RESTRICT"`
+	GlobalAdmin        bool                 `json:"GlobalAdmin" gorm:"default:false;"`
+	Appeals            []Appeal             `json:"Appeal" gorm:"foreignKey:Creator;references:ID;constraint:OnDelete:CASCADE"`
+	AppealResponses    []AppealResponse     `json:"AppealResponses" gorm:"foreignKey:Author;references:ID;constraint:OnDelete:CASCADE"`
+	PremiumType        int                  `json:"PremiumType"  gorm:"type:tinyint;default:0;"`
+	ExternalIdentities []ExternalIdentity   `json:"ExternalIdentities" gorm:"foreignKey:User;references:ID;constraint:OnDelete:CASCADE"`
+	RefreshTokens      []RefreshToken       `json:"-" gorm:"foreignKey:User;references:ID;constraint:OnDelete:CASCADE"`
 }
 
 type Organisation struct {
 	Base
-	Name            string           `json:"Name"`
-	Url             string           `json:"Url" gorm:"uniqueIndex;type:char(50);"`
-	IconHash        *string          `json:"IconHash"`
-	Description     string           `json:"Description"`
-	Moderators      []*User          `json:"Moderators" gorm:"many2many:organisation_moderators;"`
-	OwnerID         uuid.UUID        `json:"Owner"`
-	Verified        bool             `json:"Verified"`
-	AppealTemplates []AppealTemplate `json:"AppealTemplates" gorm:"foreignKey:Organisation;references:ID;constraint:OnDelete:CASCADE"`
-	Appeals         []Appeal         `json:"Appeal" gorm:"foreignKey:Organisation;references:ID;constraint:OnDelete:CASCADE"`
+	Name             string               `json:"Name"`
+	Url              string               `json:"Url" gorm:"uniqueIndex;type:char(50);"`
+	IconHash         *string              `json:"IconHash"`
+	Description      string               `json:"Description"`
+	Members          []OrganisationMember `json:"Members" gorm:"foreignKey:Organisation;references:ID;constraint:OnDelete:CASCADE"`
+	Roles            []OrganisationRole   `json:"Roles" gorm:"foreignKey:Organisation;references:ID;constraint:OnDelete:CASCADE"`
+	OwnerID          uuid.UUID            `json:"Owner"`
+	Verified         bool                 `json:"Verified"`
+	RequireTwoFactor bool                 `json:"RequireTwoFactor" gorm:"default:false;"`
+	AppealTemplates  []AppealTemplate     `json:"AppealTemplates" gorm:"foreignKey:Organisation;references:ID;constraint:OnDelete:CASCADE"`
+	Appeals          []Appeal             `json:"Appeal" gorm:"foreignKey:Organisation;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// OrganisationMember gives a user a role in an organisation. The owner is not a
+// member; they always hold every permission.
+type OrganisationMember struct {
+	Base
+	Organisation uuid.UUID         `json:"Organisation" gorm:"uniqueIndex:idx_organisation_member;type:char(36);"`
+	User         uuid.UUID         `json:"User" gorm:"uniqueIndex:idx_organisation_member;type:char(36);"`
+	Role         string            `json:"Role" gorm:"type:varchar(32);"`
+	CustomRoleID *uuid.UUID        `json:"CustomRoleID"`
+	CustomRole   *OrganisationRole `json:"CustomRole,omitempty" gorm:"foreignKey:CustomRoleID;references:ID;constraint:OnDelete:RESTRICT"`
+}
+
+// OrganisationRole is a custom role defined by an organisation.
+type OrganisationRole struct {
+	Base
+	Organisation uuid.UUID  `json:"Organisation" gorm:"index;type:char(36);"`
+	Name         string     `json:"Name" gorm:"type:varchar(64);"`
+	Permissions  StringList `json:"Permissions" gorm:"type:text;"`
+}
+
+// OrganisationInvite offers a role in an organisation to whoever owns the email
+// address or Discord account it was sent to.
+type OrganisationInvite struct {
+	Base
+	Organisation    uuid.UUID  `json:"Organisation" gorm:"index;type:char(36);"`
+	InvitedBy       uuid.UUID  `json:"InvitedBy"`
+	Email           *string    `json:"Email" gorm:"type:varchar(256);"`
+	DiscordUsername *string    `json:"DiscordUsername" gorm:"type:varchar(64);"`
+	Role            string     `json:"Role" gorm:"type:varchar(36);"`
+	TokenHash       string     `json:"-" gorm:"uniqueIndex;type:char(64);"`
+	ExpiresAt       time.Time  `json:"ExpiresAt"`
+	AcceptedAt      *time.Time `json:"AcceptedAt"`
+	AcceptedBy      *uuid.UUID `json:"AcceptedBy"`
+	DeclinedAt      *time.Time `json:"DeclinedAt"`
+	RevokedAt       *time.Time `json:"RevokedAt"`
+}
+
+// OwnershipTransfer hands an organisation to a new owner once they accept it.
+// Completed transfers are kept as the organisation's ownership history.
+type OwnershipTransfer struct {
+	Base
+	Organisation uuid.UUID  `json:"Organisation" gorm:"index;type:char(36);"`
+	FromUser     uuid.UUID  `json:"FromUser"`
+	ToUser       uuid.UUID  `json:"ToUser"`
+	OverriddenBy *uuid.UUID `json:"OverriddenBy"`
+	ExpiresAt    time.Time  `json:"ExpiresAt"`
+	AcceptedAt   *time.Time `json:"AcceptedAt"`
+	DeclinedAt   *time.Time `json:"DeclinedAt"`
+	CancelledAt  *time.Time `json:"CancelledAt"`
+}
+
+// CustomDomain points a domain the organisation controls at its appeals page.
+// It is only used once the organisation has proved it owns the domain, and only
+// one organisation can have a domain verified at a time.
+type CustomDomain struct {
+	Base
+	Organisation       uuid.UUID  `json:"Organisation" gorm:"index;type:char(36);"`
+	Domain             string     `json:"Domain" gorm:"index;type:varchar(253);"`
+	VerificationToken  string     `json:"VerificationToken" gorm:"type:char(32);"`
+	VerificationMethod string     `json:"VerificationMethod" gorm:"type:varchar(8);"`
+	VerifiedAt         *time.Time `json:"VerifiedAt"`
+	LastCheckedAt      *time.Time `json:"LastCheckedAt"`
 }
 
 type AppealTemplate struct {
 	Base
-	Organisation         uuid.UUID             `json:"Organisation"`
-	Name                 string                `json:"Name"`
-	Appeals              []Appeal              `json:"Appeal" gorm:"foreignKey:Template;references:ID;constraint:OnDelete:CASCADE"`
-	AppealTemplateFields []AppealTemplateField `json:"AppealTemplateFields" gorm:"foreignKey:Template;references:ID;constraint:OnDelete:CASCADE"`
+	Organisation         uuid.UUID               `json:"Organisation"`
+	Name                 string                  `json:"Name"`
+	Version              int                     `json:"Version"`
+	Status               string                  `json:"Status" gorm:"index;type:varchar(16);"`
+	OpensAt              *time.Time              `json:"OpensAt"`
+	ClosesAt             *time.Time              `json:"ClosesAt"`
+	Appeals              []Appeal                `json:"Appeal" gorm:"foreignKey:Template;references:ID;constraint:OnDelete:CASCADE"`
+	Versions             []AppealTemplateVersion `json:"-" gorm:"foreignKey:Template;references:ID;constraint:OnDelete:CASCADE"`
+	Sections             []AppealTemplateSection `json:"Sections" gorm:"-"`
+	AppealTemplateFields []AppealTemplateField   `json:"AppealTemplateFields" gorm:"-"`
+}
+
+// AfterFind exposes the sections and questions of the template's current
+// version, when it has been preloaded.
+func (template *AppealTemplate) AfterFind(tx *gorm.DB) error {
+	for _, version := range template.Versions {
+		if version.Current {
+			template.Sections = version.Sections
+			template.AppealTemplateFields = version.Fields
+		}
+	}
+	return nil
 }
 
+// IsOpen reports whether the time is within the template's open and close
+// window, whatever its status.
+func (template AppealTemplate) IsOpen(now time.Time) bool {
+	if template.OpensAt != nil && now.Before(*template.OpensAt) {
+		return false
+	}
+	return template.ClosesAt == nil || now.Before(*template.ClosesAt)
+}
+
+// AppealTemplateVersion is a snapshot of a template's questions which never
+// changes once created. Editing a template creates a new version, so appeals
+// keep the questions they were answered against.
+type AppealTemplateVersion struct {
+	Base
+	Template     uuid.UUID               `json:"Template" gorm:"uniqueIndex:idx_template_version;type:char(36);"`
+	Number       int                     `json:"Number" gorm:"uniqueIndex:idx_template_version;"`
+	Current      bool                    `json:"Current" gorm:"default:false;"`
+	Name         string                  `json:"Name"`
+	CreatedBy    *uuid.UUID              `json:"CreatedBy"`
+	RestoredFrom *int                    `json:"RestoredFrom"`
+	Sections     []AppealTemplateSection `json:"Sections,omitempty" gorm:"foreignKey:Version;references:ID;constraint:OnDelete:CASCADE"`
+	Fields       []AppealTemplateField   `json:"Fields,omitempty" gorm:"foreignKey:Version;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// AppealTemplateSection groups questions into a titled step of the form, so long
+// forms can be filled in a page at a time. Questions refer to their section by
+// its key.
+type AppealTemplateSection struct {
+	Base
+	Version     uuid.UUID `json:"Version" gorm:"index;type:char(36);"`
+	Key         string    `json:"Key" gorm:"column:section_key;type:varchar(64);"`
+	Title       string    `json:"Title"`
+	Description string    `json:"Description"`
+	Position    int       `json:"Position"`
+}
+
+// AppealTemplateField is one question on an appeal form. Which settings apply
+// depends on its Type, see the type constants in the fields package.
 type AppealTemplateField struct {
 	Base
-	Template       uuid.UUID      `json:"Template"`
-	Title          string         `json:"Title"`
-	Type           string         `json:"Type"`
-	CharacterLimit int            `json:"CharacterLimit"`
-	Description    string         `json:"Description"`
-	Placeholder    string         `json:"Placeholder"`
-	AppealAnswers  []AppealAnswer `json:"AppealAnswers" gorm:"foreignKey:Field;references:ID;constraint:OnDelete:CASCADE"`
+	Template       uuid.UUID       `json:"Template"`
+	Version        uuid.UUID       `json:"Version" gorm:"index;type:char(36);"`
+	Key            string          `json:"Key" gorm:"column:field_key;type:varchar(64);"`
+	Section        string          `json:"Section" gorm:"type:varchar(64);"`
+	Position       int             `json:"Position"`
+	Title          string          `json:"Title"`
+	Type           string          `json:"Type"`
+	CharacterLimit int             `json:"CharacterLimit"`
+	Required       bool            `json:"Required" gorm:"default:false;"`
+	Options        StringList      `json:"Options" gorm:"type:text;"`
+	Extensions     StringList      `json:"Extensions" gorm:"type:text;"`
+	Min            *float64        `json:"Min"`
+	Max            *float64        `json:"Max"`
+	Pattern        string          `json:"Pattern" gorm:"type:varchar(256);"`
+	ShowWhen       FieldConditions `json:"ShowWhen" gorm:"type:text;"`
+	RequireWhen    FieldConditions `json:"RequireWhen" gorm:"type:text;"`
+	Description    string          `json:"Description"`
+	Placeholder    string          `json:"Placeholder"`
+	AppealAnswers  []AppealAnswer  `json:"AppealAnswers" gorm:"foreignKey:Field;references:ID;constraint:OnDelete:CASCADE"`
 }
 
 type Appeal struct {
 	Base
-	Organisation  uuid.UUID        `json:"Organisation"`
-	Creator       uuid.UUID        `json:"Creator"`
-	Responded     bool             `json:"Responded"`
-	Responses     []AppealResponse `json:"Responses" gorm:"foreignKey:Appeal;references:ID;constraint:OnDelete:CASCADE"`
-	Content       json.RawMessage  `json:"Content"`
-	Template      uuid.UUID        `json:"Template"`
-	AppealStatus  int              `json:"AppealStatus" gorm:"type:tinyint;default:0;"`
-	AppealAnswers []AppealAnswer   `json:"AppealAnswers" gorm:"foreignKey:Appeal;references:ID;constraint:OnDelete:CASCADE"`
+	Organisation    uuid.UUID          `json:"Organisation"`
+	Creator         uuid.UUID          `json:"Creator"`
+	Responded       bool               `json:"Responded"`
+	Responses       []AppealResponse   `json:"Responses" gorm:"foreignKey:Appeal;references:ID;constraint:OnDelete:CASCADE"`
+	Content         json.RawMessage    `json:"Content"`
+	Template        uuid.UUID          `json:"Template"`
+	TemplateVersion uuid.UUID          `json:"TemplateVersion" gorm:"index;type:char(36);"`
+	Status          string             `json:"Status" gorm:"index;type:varchar(32);default:submitted;"`
+	StatusChangedAt time.Time          `json:"StatusChangedAt" gorm:"index;"`
+	AssignedTo      *uuid.UUID         `json:"AssignedTo" gorm:"index;type:char(36);"`
+	Tags            StringList         `json:"Tags" gorm:"type:text;"`
+	Transitions     []AppealTransition `json:"Transitions,omitempty" gorm:"foreignKey:Appeal;references:ID;constraint:OnDelete:CASCADE"`
+	AppealAnswers   []AppealAnswer     `json:"AppealAnswers" gorm:"foreignKey:Appeal;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// AppealTransition records a change of an appeal's status. Actor is empty when
+// the change was made by the system, such as an appeal expiring.
+type AppealTransition struct {
+	Base
+	Appeal     uuid.UUID  `json:"Appeal" gorm:"index;type:char(36);"`
+	FromStatus string     `json:"FromStatus" gorm:"type:varchar(32);"`
+	ToStatus   string     `json:"ToStatus" gorm:"type:varchar(32);"`
+	ActorType  string     `json:"ActorType" gorm:"type:varchar(16);"`
+	Actor      *uuid.UUID `json:"Actor"`
+	Response   *uuid.UUID `json:"Response"`
+	Reason     string     `json:"Reason"`
 }
 
 type AppealResponse struct {
 	Base
-	Appeal   uuid.UUID `json:"Appeal"`
-	Author   uuid.UUID `json:"Author"`
-	Content  string    `json:"Content"`
-	Decision int       `json:"Decision" gorm:"type:tinyint;default:0;"`
+	Appeal  uuid.UUID `json:"Appeal"`
+	Author  uuid.UUID `json:"Author"`
+	Content string    `json:"Content"`
 }
 
 type AppealAnswer struct {
@@ -80,6 +240,95 @@ type AppealAnswer struct {
 	Content string    `json:"Content"`
 }
 
+type ExternalIdentity struct {
+	Base
+	User         uuid.UUID `json:"User"`
+	Provider     string    `json:"Provider" gorm:"uniqueIndex:idx_provider_subject;type:varchar(32);"`
+	SubjectID    string    `json:"SubjectID" gorm:"uniqueIndex:idx_provider_subject;type:varchar(64);"`
+	Username     string    `json:"Username"`
+	Avatar       string    `json:"Avatar"`
+	AccessToken  string    `json:"-"`
+	RefreshToken string    `json:"-"`
+	TokenExpiry  time.Time `json:"-"`
+}
+
+type EmailVerification struct {
+	Base
+	User      uuid.UUID  `json:"User"`
+	Email     string     `json:"Email" gorm:"type:varchar(256);"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;type:char(64);"`
+	ExpiresAt time.Time  `json:"ExpiresAt"`
+	UsedAt    *time.Time `json:"UsedAt"`
+}
+
+type PasswordReset struct {
+	Base
+	User      uuid.UUID  `json:"User"`
+	Email     string     `json:"Email"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;type:char(64);"`
+	ExpiresAt time.Time  `json:"ExpiresAt"`
+	UsedAt    *time.Time `json:"UsedAt"`
+}
+
+type RecoveryCode struct {
+	Base
+	User     uuid.UUID  `json:"User"`
+	CodeHash string     `json:"-" gorm:"type:char(64);"`
+	UsedAt   *time.Time `json:"UsedAt"`
+}
+
+type TwoFactorChallenge struct {
+	Base
+	User      uuid.UUID `json:"User"`
+	TokenHash string    `json:"-" gorm:"uniqueIndex;type:char(64);"`
+	Attempts  int       `json:"Attempts"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+}
+
+type RefreshToken struct {
+	Base
+	User      uuid.UUID  `json:"User"`
+	Family    uuid.UUID  `json:"Family" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;type:char(64);"`
+	UserAgent string     `json:"UserAgent"`
+	IPAddress string     `json:"IPAddress" gorm:"type:varchar(45);"`
+	ExpiresAt time.Time  `json:"ExpiresAt"`
+	RotatedAt *time.Time `json:"RotatedAt"`
+	RevokedAt *time.Time `json:"RevokedAt"`
+}
+
+type LoginAttempt struct {
+	Base
+	StateHash    string     `json:"-" gorm:"uniqueIndex;type:char(64);"`
+	Provider     string     `json:"Provider" gorm:"type:varchar(32);"`
+	User         *uuid.UUID `json:"User"`
+	CodeVerifier string     `json:"-"`
+	RedirectTo   string     `json:"RedirectTo"`
+	ExpiresAt    time.Time  `json:"ExpiresAt"`
+}
+
+type APIKey struct {
+	Base
+	User         uuid.UUID  `json:"User"`
+	Organisation uuid.UUID  `json:"Organisation"`
+	Name         string     `json:"Name"`
+	Prefix       string     `json:"Prefix" gorm:"uniqueIndex;type:varchar(16);"`
+	KeyHash      string     `json:"-" gorm:"type:char(64);"`
+	Scopes       StringList `json:"Scopes" gorm:"type:text;"`
+	ExpiresAt    *time.Time `json:"ExpiresAt"`
+	LastUsedAt   *time.Time `json:"LastUsedAt"`
+	RevokedAt    *time.Time `json:"RevokedAt"`
+}
+
+type SigningKey struct {
+	Base
+	Kid        string     `json:"Kid" gorm:"uniqueIndex;type:varchar(64);"`
+	Algorithm  string     `json:"Algorithm" gorm:"type:varchar(16);"`
+	PrivateKey string     `json:"-" gorm:"type:text;"`
+	RetiredAt  *time.Time `json:"RetiredAt"`
+	ExpiresAt  *time.Time `json:"ExpiresAt"`
+}
+
 type Base struct {
 	gorm.Model
 	ID uuid.UUID `json:"ID" gorm:"type:char(36);primary_key;uniqueIndex"`
@@ -89,3 +338,63 @@ func (base *Base) BeforeCreate(tx *gorm.DB) (err error) {
 	base.ID = uuid.New()
 	return
 }
+
+// StringList is stored as a JSON array in a single column.
+type StringList []string
+
+func (list StringList) Value() (driver.Value, error) {
+	if list == nil {
+		return "[]", nil
+	}
+	value, err := json.Marshal(list)
+	return string(value), err
+}
+
+func (list *StringList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, list)
+	case string:
+		return json.Unmarshal([]byte(v), list)
+	case nil:
+		*list = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", value)
+	}
+}
+
+// FieldCondition holds when the answer to the field with the key Field matches
+// Value, or one of Values, using Operator.
+type FieldCondition struct {
+	Field    string   `json:"Field"`
+	Operator string   `json:"Operator"`
+	Value    string   `json:"Value,omitempty"`
+	Values   []string `json:"Values,omitempty"`
+}
+
+// FieldConditions must all hold, and are stored as a JSON array in a single
+// column.
+type FieldConditions []FieldCondition
+
+func (conditions FieldConditions) Value() (driver.Value, error) {
+	if conditions == nil {
+		return "[]", nil
+	}
+	value, err := json.Marshal(conditions)
+	return string(value), err
+}
+
+func (conditions *FieldConditions) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, conditions)
+	case string:
+		return json.Unmarshal([]byte(v), conditions)
+	case nil:
+		*conditions = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into FieldConditions", value)
+	}
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+// Every model must parse, as a relationship gorm cannot resolve makes every
+// query touching the model fail.
+func TestSchemasParse(t *testing.T) {
+	models := []interface{}{
+		&User{},
+		&Organisation{},
+		&OrganisationMember{},
+		&OrganisationRole{},
+		&OrganisationInvite{},
+		&OwnershipTransfer{},
+		&CustomDomain{},
+		&AppealTemplate{},
+		&AppealTemplateVersion{},
+		&AppealTemplateSection{},
+		&AppealTemplateField{},
+		&Appeal{},
+		&AppealTransition{},
+		&AppealResponse{},
+		&AppealAnswer{},
+		&ExternalIdentity{},
+		&EmailVerification{},
+		&PasswordReset{},
+		&RecoveryCode{},
+		&TwoFactorChallenge{},
+		&RefreshToken{},
+		&LoginAttempt{},
+		&APIKey{},
+		&SigningKey{},
+	}
+
+	cache := &sync.Map{}
+	for _, m := range models {
+		if _, err := schema.Parse(m, cache, schema.NamingStrategy{}); err != nil {
+			t.Errorf("%T: %v", m, err)
+		}
+	}
+}
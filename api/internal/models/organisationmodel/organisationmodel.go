@@ -0,0 +1,75 @@
+package organisationmodel
+
+import (
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+type RoleAssignmentRequest struct {
+	// Role is the name of a built in role or the ID of a custom role
+	Role string `json:"role"`
+}
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type RolesResponse struct {
+	BuiltIn     map[string][]string      `json:"builtIn"`
+	Custom      []model.OrganisationRole `json:"custom"`
+	Permissions []string                 `json:"permissions"`
+}
+
+type CreateInviteRequest struct {
+	Email           string `json:"email"`
+	DiscordUsername string `json:"discordUsername"`
+	Role            string `json:"role"`
+}
+
+type CreateInviteResponse struct {
+	Link   string                   `json:"link"`
+	Invite model.OrganisationInvite `json:"invite"`
+}
+
+type InviteTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type OwnershipTransferRequest struct {
+	UserId uuid.UUID `json:"userId"`
+}
+
+type AddDomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+type VerifyDomainRequest struct {
+	// Method is either dns or http
+	Method string `json:"method"`
+}
+
+type DomainChallengeResponse struct {
+	Domain    model.CustomDomain `json:"domain"`
+	TXTRecord string             `json:"txtRecord"`
+	HTTPUrl   string             `json:"httpUrl"`
+	Value     string             `json:"value"`
+}
+
+// PublicOrganisation is the branding anyone can see before logging in.
+type PublicOrganisation struct {
+	ID          uuid.UUID               `json:"id"`
+	Name        string                  `json:"name"`
+	Url         string                  `json:"url"`
+	IconHash    *string                 `json:"iconHash"`
+	Description string                  `json:"description"`
+	Verified    bool                    `json:"verified"`
+	Templates   []PublicTemplateSummary `json:"templates"`
+}
+
+type PublicTemplateSummary struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	// Open is whether the form is accepting appeals right now
+	Open bool `json:"open"`
+}
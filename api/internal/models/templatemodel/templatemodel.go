@@ -0,0 +1,209 @@
+package templatemodel
+
+import (
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/google/uuid"
+)
+
+// PublicTemplate is the appeal form shown to appellants, without any answers or
+// moderator data.
+type PublicTemplate struct {
+	ID           uuid.UUID               `json:"id"`
+	Organisation uuid.UUID               `json:"organisation"`
+	Name         string                  `json:"name"`
+	Version      int                     `json:"version"`
+	OpensAt      *time.Time              `json:"opensAt"`
+	ClosesAt     *time.Time              `json:"closesAt"`
+	Open         bool                    `json:"open"`
+	Sections     []PublicTemplateSection `json:"sections"`
+	Fields       []PublicTemplateField   `json:"fields"`
+}
+
+type PublicTemplateField struct {
+	ID             uuid.UUID              `json:"id"`
+	Key            string                 `json:"key"`
+	Section        string                 `json:"section"`
+	Position       int                    `json:"position"`
+	Title          string                 `json:"title"`
+	Type           string                 `json:"type"`
+	CharacterLimit int                    `json:"characterLimit"`
+	Required       bool                   `json:"required"`
+	Options        []string               `json:"options"`
+	Extensions     []string               `json:"extensions"`
+	Min            *float64               `json:"min"`
+	Max            *float64               `json:"max"`
+	Pattern        string                 `json:"pattern"`
+	ShowWhen       []model.FieldCondition `json:"showWhen"`
+	RequireWhen    []model.FieldCondition `json:"requireWhen"`
+	Description    string                 `json:"description"`
+	Placeholder    string                 `json:"placeholder"`
+}
+
+// ValidationErrorResponse explains why a template could not be saved, keyed by
+// the position of each field with a problem.
+type ValidationErrorResponse struct {
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// VersionDiff lists how the questions changed between two versions of a
+// template. Questions are matched up by their key.
+type VersionDiff struct {
+	From     int                         `json:"from"`
+	To       int                         `json:"to"`
+	Name     *ValueChange                `json:"name,omitempty"`
+	Sections *ValueChange                `json:"sections,omitempty"`
+	Added    []model.AppealTemplateField `json:"added"`
+	Removed  []model.AppealTemplateField `json:"removed"`
+	Changed  []FieldChange               `json:"changed"`
+}
+
+type FieldChange struct {
+	Key     string                 `json:"key"`
+	Changes map[string]ValueChange `json:"changes"`
+}
+
+type ValueChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// ReorderRequest lists every section and question of a template in the order
+// they should be shown.
+type ReorderRequest struct {
+	Sections []string         `json:"sections"`
+	Fields   []FieldPlacement `json:"fields"`
+}
+
+// FieldPlacement puts a question into a section, which is left empty when the
+// template has no sections.
+type FieldPlacement struct {
+	Key     string `json:"key"`
+	Section string `json:"section"`
+}
+
+// PublicTemplateSection is one step of a form, shown with the questions which
+// refer to its key.
+type PublicTemplateSection struct {
+	Key         string `json:"key"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+func NewPublicTemplateSection(section model.AppealTemplateSection) PublicTemplateSection {
+	return PublicTemplateSection{Key: section.Key, Title: section.Title, Description: section.Description}
+}
+
+func NewPublicTemplate(template model.AppealTemplate) PublicTemplate {
+	sections := []PublicTemplateSection{}
+	for _, section := range template.Sections {
+		sections = append(sections, NewPublicTemplateSection(section))
+	}
+
+	fields := []PublicTemplateField{}
+	for _, field := range template.AppealTemplateFields {
+		fields = append(fields, PublicTemplateField{
+			ID:             field.ID,
+			Key:            field.Key,
+			Section:        field.Section,
+			Position:       field.Position,
+			Title:          field.Title,
+			Type:           field.Type,
+			CharacterLimit: field.CharacterLimit,
+			Required:       field.Required,
+			Options:        field.Options,
+			Extensions:     field.Extensions,
+			Min:            field.Min,
+			Max:            field.Max,
+			Pattern:        field.Pattern,
+			ShowWhen:       field.ShowWhen,
+			RequireWhen:    field.RequireWhen,
+			Description:    field.Description,
+			Placeholder:    field.Placeholder,
+		})
+	}
+
+	return PublicTemplate{
+		ID:           template.ID,
+		Organisation: template.Organisation,
+		Name:         template.Name,
+		Version:      template.Version,
+		OpensAt:      template.OpensAt,
+		ClosesAt:     template.ClosesAt,
+		Open:         template.IsOpen(time.Now()),
+		Sections:     sections,
+		Fields:       fields,
+	}
+}
+
+// TemplateDocument is a template written out so it can be imported into any
+// organisation. Version is the version of the document format, not of the
+// template.
+type TemplateDocument struct {
+	Format   string            `json:"format" yaml:"format"`
+	Version  int               `json:"version" yaml:"version"`
+	Name     string            `json:"name" yaml:"name"`
+	Sections []DocumentSection `json:"sections,omitempty" yaml:"sections,omitempty"`
+	Fields   []DocumentField   `json:"fields" yaml:"fields"`
+}
+
+type DocumentSection struct {
+	Key         string `json:"key" yaml:"key"`
+	Title       string `json:"title" yaml:"title"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+type DocumentField struct {
+	Key            string              `json:"key" yaml:"key"`
+	Section        string              `json:"section,omitempty" yaml:"section,omitempty"`
+	Title          string              `json:"title" yaml:"title"`
+	Type           string              `json:"type" yaml:"type"`
+	Description    string              `json:"description,omitempty" yaml:"description,omitempty"`
+	Placeholder    string              `json:"placeholder,omitempty" yaml:"placeholder,omitempty"`
+	CharacterLimit int                 `json:"characterLimit,omitempty" yaml:"characterLimit,omitempty"`
+	Required       bool                `json:"required,omitempty" yaml:"required,omitempty"`
+	Options        []string            `json:"options,omitempty" yaml:"options,omitempty"`
+	Extensions     []string            `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+	Min            *float64            `json:"min,omitempty" yaml:"min,omitempty"`
+	Max            *float64            `json:"max,omitempty" yaml:"max,omitempty"`
+	Pattern        string              `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	ShowWhen       []DocumentCondition `json:"showWhen,omitempty" yaml:"showWhen,omitempty"`
+	RequireWhen    []DocumentCondition `json:"requireWhen,omitempty" yaml:"requireWhen,omitempty"`
+}
+
+type DocumentCondition struct {
+	Field    string   `json:"field" yaml:"field"`
+	Operator string   `json:"operator" yaml:"operator"`
+	Value    string   `json:"value,omitempty" yaml:"value,omitempty"`
+	Values   []string `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+// GalleryTemplate describes one of the starter templates organisations can
+// begin from.
+type GalleryTemplate struct {
+	ID          string `json:"id"`
+	Platform    string `json:"platform"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type CloneTemplateRequest struct {
+	Organisation uuid.UUID `json:"organisation"`
+	// Conflict is what to do when the organisation already has a template with
+	// the same name, which is either rename, replace or fail
+	Conflict string `json:"conflict"`
+}
+
+// StatusRequest moves a template between draft, published and archived.
+type StatusRequest struct {
+	Status string `json:"status"`
+}
+
+// ScheduleRequest sets when a template accepts appeals. Either can be left out
+// to keep it open from or until then.
+type ScheduleRequest struct {
+	OpensAt  *time.Time `json:"opensAt"`
+	ClosesAt *time.Time `json:"closesAt"`
+}
@@ -1,8 +1,16 @@
 package oauth
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"strings"
 
+	"github.com/benhall-1/appealscc/api/internal/config"
+	"github.com/benhall-1/appealscc/api/internal/models/twitchmodel"
 	discord "github.com/ravener/discord-oauth2"
 
 	"golang.org/x/oauth2"
@@ -17,3 +25,66 @@ func DiscordOAuth() *oauth2.Config {
 		Endpoint:     discord.Endpoint,
 	}
 }
+
+// TwitchOAuth reads its endpoints from the environment so the login flow can be
+// pointed at a local fake of Twitch.
+func TwitchOAuth() *oauth2.Config {
+	return &oauth2.Config{
+		RedirectURL:  os.Getenv("TWITCH_REDIRECT_URL"),
+		ClientID:     os.Getenv("TWITCH_CLIENT_ID"),
+		ClientSecret: os.Getenv("TWITCH_CLIENT_SECRET"),
+		Scopes:       []string{"user:read:email"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   config.EnvOrDefault("TWITCH_AUTH_URL", "https://id.twitch.tv/oauth2/authorize"),
+			TokenURL:  config.EnvOrDefault("TWITCH_TOKEN_URL", "https://id.twitch.tv/oauth2/token"),
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// MicrosoftOAuth reads its endpoints from the environment so the login flow can
+// be pointed at a local stub of the Microsoft identity platform.
+func MicrosoftOAuth() *oauth2.Config {
+	return &oauth2.Config{
+		RedirectURL:  os.Getenv("MICROSOFT_REDIRECT_URL"),
+		ClientID:     os.Getenv("MICROSOFT_CLIENT_ID"),
+		ClientSecret: os.Getenv("MICROSOFT_CLIENT_SECRET"),
+		Scopes:       []string{"XboxLive.signin", "offline_access"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   config.EnvOrDefault("MICROSOFT_AUTH_URL", "https://login.microsoftonline.com/consumers/oauth2/v2.0/authorize"),
+			TokenURL:  config.EnvOrDefault("MICROSOFT_TOKEN_URL", "https://login.microsoftonline.com/consumers/oauth2/v2.0/token"),
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// GetTwitchUser looks up the user the token belongs to using the Helix API.
+func GetTwitchUser(ctx context.Context, token *oauth2.Token) (*twitchmodel.TwitchUser, error) {
+	helixURL := strings.TrimSuffix(config.EnvOrDefault("TWITCH_API_URL", "https://api.twitch.tv/helix"), "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, helixURL+"/users", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Client-Id", os.Getenv("TWITCH_CLIENT_ID"))
+
+	res, err := TwitchOAuth().Client(ctx, token).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("twitch returned %s", res.Status)
+	}
+
+	var users twitchmodel.TwitchUsersResponse
+	if err := json.NewDecoder(res.Body).Decode(&users); err != nil {
+		return nil, err
+	}
+	if len(users.Data) == 0 {
+		return nil, errors.New("twitch returned no user for the token")
+	}
+
+	return &users.Data[0], nil
+}
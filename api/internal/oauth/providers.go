@@ -0,0 +1,125 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/minecraft"
+	"github.com/benhall-1/appealscc/api/internal/models/discordmodel"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// Identity is what a provider tells us about the account that logged in with it.
+type Identity struct {
+	SubjectID     string
+	Username      string
+	Avatar        string
+	Email         string
+	EmailVerified bool
+}
+
+// Provider is implemented by every service users can log in with or link to
+// their account.
+type Provider interface {
+	Name() string
+	Config() *oauth2.Config
+	GetIdentity(ctx context.Context, token *oauth2.Token) (*Identity, error)
+}
+
+var providers = map[string]Provider{
+	"discord":   discordProvider{},
+	"twitch":    twitchProvider{},
+	"minecraft": minecraftProvider{},
+}
+
+func GetProvider(name string) (Provider, bool) {
+	provider, ok := providers[name]
+	return provider, ok
+}
+
+type discordProvider struct{}
+
+func (discordProvider) Name() string { return "discord" }
+
+func (discordProvider) Config() *oauth2.Config { return DiscordOAuth() }
+
+func (p discordProvider) GetIdentity(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	res, err := p.Config().Client(ctx, token).Get("https://discord.com/api/users/@me")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discord returned %s", res.Status)
+	}
+
+	var discordUser discordmodel.DiscordUser
+	if err := json.NewDecoder(res.Body).Decode(&discordUser); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		SubjectID:     discordUser.Id,
+		Username:      discordUser.Username,
+		Email:         discordUser.Email,
+		EmailVerified: discordUser.Verified,
+	}
+	if discordUser.Discriminator != "" && discordUser.Discriminator != "0" {
+		identity.Username = fmt.Sprintf("%s#%s", discordUser.Username, discordUser.Discriminator)
+	}
+	if discordUser.Avatar != "" {
+		identity.Avatar = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", discordUser.Id, discordUser.Avatar)
+	}
+	return identity, nil
+}
+
+type twitchProvider struct{}
+
+func (twitchProvider) Name() string { return "twitch" }
+
+func (twitchProvider) Config() *oauth2.Config { return TwitchOAuth() }
+
+func (twitchProvider) GetIdentity(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	twitchUser, err := GetTwitchUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Twitch only returns the email address once it has been verified
+	return &Identity{
+		SubjectID:     twitchUser.Id,
+		Username:      twitchUser.Login,
+		Avatar:        twitchUser.Profile_image_url,
+		Email:         twitchUser.Email,
+		EmailVerified: twitchUser.Email != "",
+	}, nil
+}
+
+// minecraftProvider logs in with a Microsoft account and identifies the user by
+// the Minecraft Java player it owns.
+type minecraftProvider struct{}
+
+func (minecraftProvider) Name() string { return "minecraft" }
+
+func (minecraftProvider) Config() *oauth2.Config { return MicrosoftOAuth() }
+
+func (minecraftProvider) GetIdentity(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	profile, err := minecraft.GetProfile(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	playerId, err := uuid.Parse(profile.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		SubjectID: playerId.String(),
+		Username:  profile.Name,
+	}, nil
+}
@@ -0,0 +1,200 @@
+package ownership
+
+import (
+	"errors"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/principal"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const transferLifetime = 7 * 24 * time.Hour
+
+var (
+	ErrNotOwner          = errors.New("only the owner can transfer the organisation")
+	ErrNotGlobalAdmin    = errors.New("only global admins can override a transfer")
+	ErrTransferToSelf    = errors.New("the organisation is already owned by this user")
+	ErrNotModerator      = errors.New("the new owner must already moderate the organisation")
+	ErrTransferNotFound  = errors.New("there is no pending transfer")
+	ErrNotRecipient      = errors.New("the transfer was offered to someone else")
+	ErrTwoFactorRequired = errors.New("the new owner must enable two-factor authentication")
+	ErrUserNotFound      = errors.New("user not found")
+)
+
+// Begin offers the organisation to one of its moderators, replacing any transfer
+// which was already pending. Ownership does not change until they accept.
+func Begin(organisation model.Organisation, toUser uuid.UUID, actor *principal.Principal) (*model.OwnershipTransfer, error) {
+	if actor.UserID != organisation.OwnerID {
+		return nil, ErrNotOwner
+	}
+	if toUser == organisation.OwnerID {
+		return nil, ErrTransferToSelf
+	}
+
+	var member model.OrganisationMember
+	if err := db.DB.Preload("CustomRole").First(&member, "organisation = ? AND user = ?", organisation.ID, toUser); err.Error != nil {
+		return nil, ErrNotModerator
+	}
+	if !rbac.IsSubset([]string{rbac.PermissionAppealsRespond}, rbac.PermissionsOf(member)) {
+		return nil, ErrNotModerator
+	}
+
+	transfer := model.OwnershipTransfer{
+		Organisation: organisation.ID,
+		FromUser:     organisation.OwnerID,
+		ToUser:       toUser,
+		ExpiresAt:    time.Now().Add(transferLifetime),
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := pending(tx).Model(&model.OwnershipTransfer{}).Where("organisation = ?", organisation.ID).Update("cancelled_at", time.Now()); err.Error != nil {
+			return err.Error
+		}
+		return tx.Create(&transfer).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// Pending returns the organisation's transfer which is waiting to be accepted.
+func Pending(organisationId uuid.UUID) (*model.OwnershipTransfer, error) {
+	var transfer model.OwnershipTransfer
+	if err := pending(db.DB).First(&transfer, "organisation = ?", organisationId); err.Error != nil {
+		return nil, ErrTransferNotFound
+	}
+	return &transfer, nil
+}
+
+// History returns every completed change of the organisation's owner, newest first.
+func History(organisationId uuid.UUID) ([]model.OwnershipTransfer, error) {
+	transfers := []model.OwnershipTransfer{}
+	if err := db.DB.Order("accepted_at desc").Find(&transfers, "organisation = ? AND accepted_at IS NOT NULL", organisationId); err.Error != nil {
+		return nil, err.Error
+	}
+	return transfers, nil
+}
+
+// Accept completes the pending transfer for its recipient, who becomes the owner.
+func Accept(organisation model.Organisation, actor *principal.Principal) (*model.OwnershipTransfer, error) {
+	transfer, err := Pending(organisation.ID)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.ToUser != actor.UserID {
+		return nil, ErrNotRecipient
+	}
+	if transfer.FromUser != organisation.OwnerID {
+		return nil, ErrTransferNotFound
+	}
+	if organisation.RequireTwoFactor && !actor.TwoFactor {
+		return nil, ErrTwoFactorRequired
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if result := pending(tx).Model(&model.OwnershipTransfer{}).Where("id = ?", transfer.ID).Update("accepted_at", now); result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return ErrTransferNotFound
+		}
+		transfer.AcceptedAt = &now
+		return changeOwner(tx, organisation, transfer.ToUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// Decline turns down the pending transfer for its recipient.
+func Decline(organisationId uuid.UUID, actor *principal.Principal) error {
+	transfer, err := Pending(organisationId)
+	if err != nil {
+		return err
+	}
+	if transfer.ToUser != actor.UserID {
+		return ErrNotRecipient
+	}
+	return db.DB.Model(transfer).Update("declined_at", time.Now()).Error
+}
+
+// Cancel withdraws the pending transfer. Only the owner who started it can.
+func Cancel(organisation model.Organisation, actor *principal.Principal) error {
+	if actor.UserID != organisation.OwnerID && !actor.GlobalAdmin {
+		return ErrNotOwner
+	}
+	transfer, err := Pending(organisation.ID)
+	if err != nil {
+		return err
+	}
+	return db.DB.Model(transfer).Update("cancelled_at", time.Now()).Error
+}
+
+// Override lets a global admin move the organisation to a new owner immediately,
+// for example when the owner has left and can no longer start a transfer.
+func Override(organisation model.Organisation, toUser uuid.UUID, actor *principal.Principal) (*model.OwnershipTransfer, error) {
+	if !actor.GlobalAdmin || actor.AuthMethod != principal.AuthMethodToken {
+		return nil, ErrNotGlobalAdmin
+	}
+	if toUser == organisation.OwnerID {
+		return nil, ErrTransferToSelf
+	}
+
+	var user model.User
+	if err := db.DB.First(&user, "Id = ?", toUser); err.Error != nil {
+		return nil, ErrUserNotFound
+	}
+	if organisation.RequireTwoFactor && !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorRequired
+	}
+
+	now := time.Now()
+	adminId := actor.UserID
+	transfer := model.OwnershipTransfer{
+		Organisation: organisation.ID,
+		FromUser:     organisation.OwnerID,
+		ToUser:       toUser,
+		OverriddenBy: &adminId,
+		ExpiresAt:    now,
+		AcceptedAt:   &now,
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := pending(tx).Model(&model.OwnershipTransfer{}).Where("organisation = ?", organisation.ID).Update("cancelled_at", now); err.Error != nil {
+			return err.Error
+		}
+		if err := tx.Create(&transfer); err.Error != nil {
+			return err.Error
+		}
+		return changeOwner(tx, organisation, toUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// changeOwner makes the user the owner, replacing any role they had, and keeps
+// the previous owner on the team as an admin.
+func changeOwner(tx *gorm.DB, organisation model.Organisation, newOwner uuid.UUID) error {
+	previousOwner := organisation.OwnerID
+
+	if err := tx.Model(&model.Organisation{}).Where("id = ?", organisation.ID).Update("owner_id", newOwner); err.Error != nil {
+		return err.Error
+	}
+	if err := tx.Unscoped().Delete(&model.OrganisationMember{}, "organisation = ? AND user IN ?", organisation.ID, []uuid.UUID{newOwner, previousOwner}); err.Error != nil {
+		return err.Error
+	}
+	return tx.Create(&model.OrganisationMember{Organisation: organisation.ID, User: previousOwner, Role: rbac.RoleAdmin}).Error
+}
+
+func pending(query *gorm.DB) *gorm.DB {
+	return query.Where("accepted_at IS NULL AND declined_at IS NULL AND cancelled_at IS NULL AND expires_at > ?", time.Now())
+}
@@ -0,0 +1,107 @@
+package principal
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type AuthMethod string
+
+const (
+	AuthMethodToken  AuthMethod = "token"
+	AuthMethodAPIKey AuthMethod = "api_key"
+)
+
+const RoleGlobalAdmin = "global_admin"
+
+// Membership is the principal's role in an organisation and the permissions it grants.
+type Membership struct {
+	Role        string
+	Permissions []string
+	// TwoFactorRequired is set when the organisation requires the people who run it to use two-factor authentication
+	TwoFactorRequired bool
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID      uuid.UUID
+	Email       string
+	GlobalAdmin bool
+	PremiumType int
+	TwoFactor   bool
+	Roles       []string
+	// Memberships maps each organisation the user helps run to their role in it
+	Memberships map[uuid.UUID]Membership
+	AuthMethod  AuthMethod
+	APIKeyID    *uuid.UUID
+	// APIKeyOrganisation is the only organisation an API key may act on
+	APIKeyOrganisation *uuid.UUID
+	Scopes             []string
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// HasScope reports whether the principal may act on the organisation with the
+// scope. Users logged in with a token are not limited by scopes.
+func (p *Principal) HasScope(organisationId uuid.UUID, scope string) bool {
+	if p.AuthMethod != AuthMethodAPIKey {
+		return true
+	}
+	if p.APIKeyOrganisation == nil || *p.APIKeyOrganisation != organisationId {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleIn returns the principal's membership of the organisation, if they have one.
+func (p *Principal) RoleIn(organisationId uuid.UUID) (Membership, bool) {
+	membership, ok := p.Memberships[organisationId]
+	return membership, ok
+}
+
+// HasPermission reports whether the principal's role in the organisation grants
+// the permission. Global admins hold every permission, and API keys are also
+// limited to their scopes.
+func (p *Principal) HasPermission(organisationId uuid.UUID, permission string) bool {
+	if !p.HasScope(organisationId, permission) {
+		return false
+	}
+	if p.GlobalAdmin {
+		return true
+	}
+	membership, ok := p.Memberships[organisationId]
+	if !ok {
+		return false
+	}
+	for _, granted := range membership.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// SatisfiesTwoFactor reports whether the principal has two-factor authentication
+// enabled when the organisation requires it.
+func (p *Principal) SatisfiesTwoFactor(organisationId uuid.UUID) bool {
+	if p.GlobalAdmin {
+		return true
+	}
+	membership, ok := p.Memberships[organisationId]
+	return !ok || !membership.TwoFactorRequired || p.TwoFactor
+}
@@ -0,0 +1,64 @@
+package principal
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHasPermission(t *testing.T) {
+	organisation := uuid.New()
+	other := uuid.New()
+	member := map[uuid.UUID]Membership{organisation: {Role: "moderator", Permissions: []string{"appeals:read", "appeals:respond"}}}
+
+	tests := []struct {
+		name         string
+		principal    Principal
+		organisation uuid.UUID
+		permission   string
+		want         bool
+	}{
+		{"granted by role", Principal{AuthMethod: AuthMethodToken, Memberships: member}, organisation, "appeals:read", true},
+		{"not granted by role", Principal{AuthMethod: AuthMethodToken, Memberships: member}, organisation, "roles:manage", false},
+		{"not a member", Principal{AuthMethod: AuthMethodToken, Memberships: member}, other, "appeals:read", false},
+		{"global admin", Principal{AuthMethod: AuthMethodToken, GlobalAdmin: true}, other, "roles:manage", true},
+		{"api key with scope", Principal{AuthMethod: AuthMethodAPIKey, Memberships: member, APIKeyOrganisation: &organisation, Scopes: []string{"appeals:read"}}, organisation, "appeals:read", true},
+		{"api key without scope", Principal{AuthMethod: AuthMethodAPIKey, Memberships: member, APIKeyOrganisation: &organisation, Scopes: []string{"appeals:read"}}, organisation, "appeals:respond", false},
+		{"api key for another organisation", Principal{AuthMethod: AuthMethodAPIKey, GlobalAdmin: true, APIKeyOrganisation: &other, Scopes: []string{"appeals:read"}}, organisation, "appeals:read", false},
+		{"api key scope the role does not grant", Principal{AuthMethod: AuthMethodAPIKey, Memberships: member, APIKeyOrganisation: &organisation, Scopes: []string{"roles:manage"}}, organisation, "roles:manage", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.principal.HasPermission(test.organisation, test.permission); got != test.want {
+				t.Errorf("HasPermission is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSatisfiesTwoFactor(t *testing.T) {
+	organisation := uuid.New()
+	required := map[uuid.UUID]Membership{organisation: {Role: "admin", TwoFactorRequired: true}}
+	optional := map[uuid.UUID]Membership{organisation: {Role: "admin"}}
+
+	tests := []struct {
+		name      string
+		principal Principal
+		want      bool
+	}{
+		{"required and enabled", Principal{TwoFactor: true, Memberships: required}, true},
+		{"required and not enabled", Principal{Memberships: required}, false},
+		{"not required", Principal{Memberships: optional}, true},
+		{"not a member", Principal{}, true},
+		{"global admin", Principal{GlobalAdmin: true, Memberships: required}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.principal.SatisfiesTwoFactor(organisation); got != test.want {
+				t.Errorf("SatisfiesTwoFactor is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
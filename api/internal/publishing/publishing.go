@@ -0,0 +1,121 @@
+package publishing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"gorm.io/gorm"
+)
+
+const (
+	// StatusDraft templates can only be seen by the organisation, so forms can be
+	// built before appellants see them
+	StatusDraft = "draft"
+	// StatusPublished templates are shown to appellants, and accept appeals while
+	// they are open
+	StatusPublished = "published"
+	// StatusArchived templates no longer accept appeals, but keep the appeals
+	// already made against them
+	StatusArchived = "archived"
+)
+
+// Statuses lists every status a template can be in.
+var Statuses = []string{StatusDraft, StatusPublished, StatusArchived}
+
+var (
+	ErrInvalidStatus   = errors.New("template status must be draft, published or archived")
+	ErrAlreadyInStatus = errors.New("template is already in this status")
+	ErrNoQuestions     = errors.New("templates need at least one question to be published")
+	ErrInvalidWindow   = errors.New("templates must open before they close")
+	ErrNotPublished    = errors.New("template is not published")
+	ErrArchived        = errors.New("template is archived")
+	ErrNotOpenYet      = errors.New("template is not open for appeals yet")
+	ErrClosed          = errors.New("template has closed for appeals")
+	ErrTemplateLimit   = errors.New("organisation has reached its template limit")
+)
+
+// IsStatus reports whether the status is one templates can be in.
+func IsStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStatus moves the template into the status. Templates can move between any
+// of the statuses, but only those with questions can be published, which must
+// have been loaded with versions.Preload. Archived templates do not count towards
+// the organisation's limit, so one can only be brought back while the
+// organisation has fewer than limit templates in use, where 0 is no limit.
+func SetStatus(template *model.AppealTemplate, status string, limit int) error {
+	if !IsStatus(status) {
+		return ErrInvalidStatus
+	}
+	if template.Status == status {
+		return ErrAlreadyInStatus
+	}
+	if status == StatusPublished && len(template.AppealTemplateFields) == 0 {
+		return ErrNoQuestions
+	}
+	if template.Status == StatusArchived && limit > 0 {
+		var inUse int64
+		if err := db.DB.Model(&model.AppealTemplate{}).Scopes(NotArchived).Where("organisation = ?", template.Organisation).Count(&inUse); err.Error != nil {
+			return err.Error
+		}
+		if inUse >= int64(limit) {
+			return ErrTemplateLimit
+		}
+	}
+
+	if err := db.DB.Model(template).Update("status", status); err.Error != nil {
+		return err.Error
+	}
+	template.Status = status
+	return nil
+}
+
+// Schedule sets when the template opens and closes for appeals, either of which
+// can be left out to keep it open from or until then.
+func Schedule(template *model.AppealTemplate, opensAt *time.Time, closesAt *time.Time) error {
+	if opensAt != nil && closesAt != nil && !closesAt.After(*opensAt) {
+		return ErrInvalidWindow
+	}
+
+	if err := db.DB.Model(template).Updates(map[string]interface{}{"opens_at": opensAt, "closes_at": closesAt}); err.Error != nil {
+		return err.Error
+	}
+	template.OpensAt = opensAt
+	template.ClosesAt = closesAt
+	return nil
+}
+
+// AcceptingAppeals returns why new appeals cannot be made against the template,
+// or nothing if they can.
+func AcceptingAppeals(template model.AppealTemplate, now time.Time) error {
+	switch {
+	case template.Status == StatusArchived:
+		return ErrArchived
+	case template.Status != StatusPublished:
+		return ErrNotPublished
+	case template.OpensAt != nil && now.Before(*template.OpensAt):
+		return ErrNotOpenYet
+	case !template.IsOpen(now):
+		return ErrClosed
+	}
+	return nil
+}
+
+// Published selects only the templates shown to appellants.
+func Published(query *gorm.DB) *gorm.DB {
+	return query.Where("status = ?", StatusPublished)
+}
+
+// NotArchived selects the templates still in use, which are those that count
+// towards an organisation's limit.
+func NotArchived(query *gorm.DB) *gorm.DB {
+	return query.Where("status <> ?", StatusArchived)
+}
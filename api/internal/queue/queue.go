@@ -0,0 +1,373 @@
+package queue
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/lifecycle"
+	"github.com/benhall-1/appealscc/api/internal/models/appealmodel"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// SortCreated lists the newest appeals first
+	SortCreated = "created"
+	// SortUpdated lists the most recently changed appeals first
+	SortUpdated = "updated"
+	// SortOldestWaiting lists only the appeals waiting on moderators, those
+	// which have waited longest since entering their status first
+	SortOldestWaiting = "oldest_waiting"
+)
+
+// Sorts lists every order appeals can be listed in.
+var Sorts = []string{SortCreated, SortUpdated, SortOldestWaiting}
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+	maxTags      = 20
+)
+
+// Unassigned is given as the assigned moderator to find appeals nobody has been
+// assigned to.
+const Unassigned = "none"
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9 _-]{0,31}$`)
+
+var (
+	ErrInvalidCursor = errors.New("cursor is not valid for this listing")
+	ErrNotModerator  = errors.New("appeals can only be assigned to members who can respond to them")
+	ErrInvalidTag    = errors.New("tags must be 1 to 32 letters, numbers, spaces, dashes or underscores")
+	ErrTooManyTags   = errors.New("appeals can have at most 20 tags")
+)
+
+// InvalidParameterError is returned when one of the listing's query parameters
+// cannot be understood.
+type InvalidParameterError struct {
+	Parameter string
+	Reason    string
+}
+
+func (e *InvalidParameterError) Error() string {
+	return fmt.Sprintf("'%s' %s", e.Parameter, e.Reason)
+}
+
+// Query filters, sorts and pages an organisation's appeals. Every filter given
+// must match, and an appeal must have all of the Tags.
+type Query struct {
+	Statuses   []string
+	Template   *uuid.UUID
+	Creator    *uuid.UUID
+	AssignedTo *uuid.UUID
+	Unassigned bool
+	From       *time.Time
+	To         *time.Time
+	Tags       []string
+	Responded  *bool
+	Sort       string
+	Ascending  bool
+	Limit      int
+	Cursor     string
+}
+
+// cursor marks where the previous page ended, so the next page carries on from
+// it even if appeals are added in the meantime.
+type cursor struct {
+	Sort      string    `json:"s"`
+	Ascending bool      `json:"a"`
+	At        time.Time `json:"t"`
+	ID        uuid.UUID `json:"i"`
+}
+
+// Parse reads a query from the listing's query parameters. Statuses and tags are
+// comma separated, dates are either RFC 3339 times or whole days, and the order
+// of created and updated can be flipped with order=asc.
+func Parse(values url.Values) (Query, error) {
+	query := Query{Sort: SortCreated, Limit: defaultLimit, Cursor: values.Get("cursor")}
+
+	for _, status := range list(values.Get("status")) {
+		if !lifecycle.IsStatus(status) {
+			return query, &InvalidParameterError{Parameter: "status", Reason: fmt.Sprintf("must be one of %v", lifecycle.Statuses)}
+		}
+		query.Statuses = append(query.Statuses, status)
+	}
+
+	var err error
+	if query.Template, err = parseID(values, "template"); err != nil {
+		return query, err
+	}
+	if query.Creator, err = parseID(values, "creator"); err != nil {
+		return query, err
+	}
+	if value := values.Get("assignedTo"); value == Unassigned {
+		query.Unassigned = true
+	} else if value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return query, &InvalidParameterError{Parameter: "assignedTo", Reason: "must be an ID, or none for unassigned appeals"}
+		}
+		query.AssignedTo = &id
+	}
+
+	if query.From, err = parseTime(values.Get("from"), false); err != nil {
+		return query, &InvalidParameterError{Parameter: "from", Reason: "must be a date such as 2021-12-31 or an RFC 3339 time"}
+	}
+	if query.To, err = parseTime(values.Get("to"), true); err != nil {
+		return query, &InvalidParameterError{Parameter: "to", Reason: "must be a date such as 2021-12-31 or an RFC 3339 time"}
+	}
+
+	for _, tag := range list(values.Get("tags")) {
+		tag = strings.ToLower(tag)
+		if !tagPattern.MatchString(tag) {
+			return query, &InvalidParameterError{Parameter: "tags", Reason: "must be 1 to 32 letters, numbers, spaces, dashes or underscores"}
+		}
+		query.Tags = append(query.Tags, tag)
+	}
+	if len(query.Tags) > maxTags {
+		return query, &InvalidParameterError{Parameter: "tags", Reason: fmt.Sprintf("must have at most %d tags", maxTags)}
+	}
+
+	if value := values.Get("responded"); value != "" {
+		responded, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, &InvalidParameterError{Parameter: "responded", Reason: "must be true or false"}
+		}
+		query.Responded = &responded
+	}
+
+	if value := values.Get("sort"); value != "" {
+		if !contains(Sorts, value) {
+			return query, &InvalidParameterError{Parameter: "sort", Reason: fmt.Sprintf("must be one of %v", Sorts)}
+		}
+		query.Sort = value
+	}
+	switch values.Get("order") {
+	case "":
+		query.Ascending = query.Sort == SortOldestWaiting
+	case "asc":
+		query.Ascending = true
+	case "desc":
+		query.Ascending = false
+	default:
+		return query, &InvalidParameterError{Parameter: "order", Reason: "must be asc or desc"}
+	}
+	if query.Sort == SortOldestWaiting && !query.Ascending {
+		return query, &InvalidParameterError{Parameter: "order", Reason: "cannot be desc when sorting by oldest_waiting"}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return query, &InvalidParameterError{Parameter: "limit", Reason: fmt.Sprintf("must be a number from 1 to %d", maxLimit)}
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// List returns a page of the organisation's appeals matching the query, with
+// how many match across every page.
+func List(organisationId uuid.UUID, query Query) (*appealmodel.AppealPage, error) {
+	page := appealmodel.AppealPage{Appeals: []model.Appeal{}}
+	if err := filtered(organisationId, query).Count(&page.Total); err.Error != nil {
+		return nil, err.Error
+	}
+
+	column := "created_at"
+	switch query.Sort {
+	case SortUpdated:
+		column = "updated_at"
+	case SortOldestWaiting:
+		column = "status_changed_at"
+	}
+	direction, comparison := "desc", "<"
+	if query.Ascending {
+		direction, comparison = "asc", ">"
+	}
+
+	selected := filtered(organisationId, query)
+	if query.Cursor != "" {
+		after, err := decodeCursor(query.Cursor)
+		if err != nil || after.Sort != query.Sort || after.Ascending != query.Ascending {
+			return nil, ErrInvalidCursor
+		}
+		// Appeals changed at the same moment are told apart by their ID
+		selected = selected.Where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, comparison), after.At, after.At, after.ID)
+	}
+
+	// One more than the page is fetched to find out whether there is another page
+	if err := selected.Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).Limit(query.Limit + 1).Find(&page.Appeals); err.Error != nil {
+		return nil, err.Error
+	}
+	if len(page.Appeals) > query.Limit {
+		page.Appeals = page.Appeals[:query.Limit]
+		last := page.Appeals[len(page.Appeals)-1]
+		at := last.CreatedAt
+		switch query.Sort {
+		case SortUpdated:
+			at = last.UpdatedAt
+		case SortOldestWaiting:
+			at = last.StatusChangedAt
+		}
+		page.NextCursor = encodeCursor(cursor{Sort: query.Sort, Ascending: query.Ascending, At: at, ID: last.ID})
+	}
+	return &page, nil
+}
+
+// Assign gives the appeal to a moderator of the organisation to deal with, or
+// unassigns it when moderator is nil. Assigning does not count as the appeal
+// being updated, so it keeps its place when sorted by most recently updated.
+func Assign(appeal *model.Appeal, organisation model.Organisation, moderator *uuid.UUID) error {
+	if moderator != nil && *moderator != organisation.OwnerID {
+		var member model.OrganisationMember
+		if err := db.DB.Preload("CustomRole").First(&member, "organisation = ? AND user = ?", organisation.ID, *moderator); err.Error != nil {
+			return ErrNotModerator
+		}
+		if !rbac.IsSubset([]string{rbac.PermissionAppealsRespond}, rbac.PermissionsOf(member)) {
+			return ErrNotModerator
+		}
+	}
+
+	if err := db.DB.Model(appeal).UpdateColumn("assigned_to", moderator); err.Error != nil {
+		return err.Error
+	}
+	appeal.AssignedTo = moderator
+	return nil
+}
+
+// SetTags replaces the appeal's tags, which are stored in lowercase without any
+// repeats. Like assigning, tagging does not count as the appeal being updated.
+func SetTags(appeal *model.Appeal, tags []string) error {
+	normalised := model.StringList{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return ErrInvalidTag
+		}
+		if !contains(normalised, tag) {
+			normalised = append(normalised, tag)
+		}
+	}
+	if len(normalised) > maxTags {
+		return ErrTooManyTags
+	}
+
+	if err := db.DB.Model(appeal).UpdateColumn("tags", normalised); err.Error != nil {
+		return err.Error
+	}
+	appeal.Tags = normalised
+	return nil
+}
+
+// filtered selects the organisation's appeals matching the query's filters.
+func filtered(organisationId uuid.UUID, query Query) *gorm.DB {
+	selected := db.DB.Model(&model.Appeal{}).Where("organisation = ?", organisationId)
+	if len(query.Statuses) > 0 {
+		selected = selected.Where("status IN ?", query.Statuses)
+	}
+	if query.Sort == SortOldestWaiting {
+		selected = selected.Where("status IN ?", lifecycle.ModeratorStatuses)
+	}
+	if query.Template != nil {
+		selected = selected.Where("template = ?", *query.Template)
+	}
+	if query.Creator != nil {
+		selected = selected.Where("creator = ?", *query.Creator)
+	}
+	if query.Unassigned {
+		selected = selected.Where("assigned_to IS NULL")
+	} else if query.AssignedTo != nil {
+		selected = selected.Where("assigned_to = ?", *query.AssignedTo)
+	}
+	if query.From != nil {
+		selected = selected.Where("created_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		selected = selected.Where("created_at < ?", *query.To)
+	}
+	for _, tag := range query.Tags {
+		encoded, _ := json.Marshal(tag)
+		selected = selected.Where("JSON_CONTAINS(tags, ?)", string(encoded))
+	}
+	if query.Responded != nil {
+		selected = selected.Where("responded = ?", *query.Responded)
+	}
+	return selected
+}
+
+func parseID(values url.Values, parameter string) (*uuid.UUID, error) {
+	value := values.Get(parameter)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, &InvalidParameterError{Parameter: parameter, Reason: "must be an ID"}
+	}
+	return &id, nil
+}
+
+// parseTime reads a time or a whole day. A day given as the end of a range
+// includes all of that day.
+func parseTime(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		if endOfDay {
+			day = day.AddDate(0, 0, 1)
+		}
+		return &day, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+func encodeCursor(c cursor) string {
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeCursor(value string) (cursor, error) {
+	var c cursor
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(decoded, &c)
+	return c, err
+}
+
+func list(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package queue
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParse(t *testing.T) {
+	id := uuid.New()
+	from := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := time.Date(2021, 12, 31, 12, 30, 0, 0, time.UTC)
+	responded := true
+	tags := strings.Split(strings.Repeat("tag,", maxTags-1)+"last", ",")
+
+	tests := []struct {
+		name      string
+		query     string
+		want      Query
+		parameter string
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  Query{Sort: SortCreated, Limit: defaultLimit},
+		},
+		{
+			name:  "filters",
+			query: "status=submitted,+under_review&template=" + id.String() + "&creator=" + id.String() + "&assignedTo=" + id.String() + "&tags=Spam,+appeal&responded=true",
+			want: Query{
+				Statuses:   []string{"submitted", "under_review"},
+				Template:   &id,
+				Creator:    &id,
+				AssignedTo: &id,
+				Tags:       []string{"spam", "appeal"},
+				Responded:  &responded,
+				Sort:       SortCreated,
+				Limit:      defaultLimit,
+			},
+		},
+		{
+			name:  "most tags",
+			query: "tags=" + strings.Join(tags, ","),
+			want:  Query{Tags: tags, Sort: SortCreated, Limit: defaultLimit},
+		},
+		{
+			name:  "unassigned",
+			query: "assignedTo=none",
+			want:  Query{Unassigned: true, Sort: SortCreated, Limit: defaultLimit},
+		},
+		{
+			name:  "whole days include the last day",
+			query: "from=2021-12-01&to=2021-12-31",
+			want:  Query{From: &from, To: &to, Sort: SortCreated, Limit: defaultLimit},
+		},
+		{
+			name:  "times",
+			query: "to=2021-12-31T12:30:00Z",
+			want:  Query{To: &at, Sort: SortCreated, Limit: defaultLimit},
+		},
+		{
+			name:  "oldest waiting is ascending",
+			query: "sort=oldest_waiting",
+			want:  Query{Sort: SortOldestWaiting, Ascending: true, Limit: defaultLimit},
+		},
+		{
+			name:  "order and limit",
+			query: "sort=updated&order=asc&limit=100&cursor=abc",
+			want:  Query{Sort: SortUpdated, Ascending: true, Limit: 100, Cursor: "abc"},
+		},
+		{name: "unknown status", query: "status=open", parameter: "status"},
+		{name: "invalid template", query: "template=1", parameter: "template"},
+		{name: "invalid creator", query: "creator=me", parameter: "creator"},
+		{name: "invalid assignee", query: "assignedTo=someone", parameter: "assignedTo"},
+		{name: "invalid from", query: "from=yesterday", parameter: "from"},
+		{name: "invalid to", query: "to=31/12/2021", parameter: "to"},
+		{name: "invalid tag", query: "tags=spam,%3Cscript%3E", parameter: "tags"},
+		{name: "tag too long", query: "tags=" + strings.Repeat("a", 33), parameter: "tags"},
+		{name: "too many tags", query: "tags=" + strings.Join(tags, ",") + ",one+more", parameter: "tags"},
+		{name: "invalid responded", query: "responded=maybe", parameter: "responded"},
+		{name: "unknown sort", query: "sort=priority", parameter: "sort"},
+		{name: "unknown order", query: "order=up", parameter: "order"},
+		{name: "oldest waiting descending", query: "sort=oldest_waiting&order=desc", parameter: "order"},
+		{name: "limit too small", query: "limit=0", parameter: "limit"},
+		{name: "limit too large", query: "limit=101", parameter: "limit"},
+		{name: "limit not a number", query: "limit=all", parameter: "limit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			query, err := Parse(values)
+			if test.parameter != "" {
+				invalid, ok := err.(*InvalidParameterError)
+				if !ok || invalid.Parameter != test.parameter {
+					t.Fatalf("error is %v, want an invalid '%s'", err, test.parameter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(query, test.want) {
+				t.Errorf("got %+v, want %+v", query, test.want)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	tests := []cursor{
+		{Sort: SortCreated, At: time.Date(2021, 12, 31, 12, 30, 0, 123456789, time.UTC), ID: uuid.New()},
+		{Sort: SortUpdated, Ascending: true, At: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), ID: uuid.New()},
+		{Sort: SortOldestWaiting, Ascending: true, At: time.Date(2020, 2, 29, 23, 59, 59, 0, time.FixedZone("BST", 3600)), ID: uuid.New()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Sort, func(t *testing.T) {
+			decoded, err := decodeCursor(encodeCursor(test))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Sort != test.Sort || decoded.Ascending != test.Ascending || !decoded.At.Equal(test.At) || decoded.ID != test.ID {
+				t.Errorf("got %+v, want %+v", decoded, test)
+			}
+		})
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	for _, value := range []string{"not base64!", "bm90IGpzb24", "eyJpIjoibm90IGFuIGlkIn0"} {
+		t.Run(value, func(t *testing.T) {
+			if _, err := decodeCursor(value); err == nil {
+				t.Errorf("decoded '%s' without an error", value)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package rbac
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/principal"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrMemberNotFound    = errors.New("member not found")
+	ErrAlreadyMember     = errors.New("user is already a member of the organisation")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrInvalidPermission = errors.New("permission is not recognised")
+	ErrInvalidRoleName   = errors.New("role name is invalid")
+	ErrRoleInUse         = errors.New("role is still assigned to members")
+	ErrCannotGrant       = errors.New("cannot grant permissions you do not hold")
+	ErrOwnerRole         = errors.New("the owner's role cannot be changed")
+	ErrTwoFactorRequired = errors.New("organisation requires members to enable two-factor authentication")
+)
+
+// PermissionsOf returns the permissions granted by the member's role.
+func PermissionsOf(member model.OrganisationMember) []string {
+	if member.Role == RoleCustom {
+		if member.CustomRole == nil {
+			return nil
+		}
+		return member.CustomRole.Permissions
+	}
+	return BuiltInRoles[member.Role]
+}
+
+// Members returns everyone with a role in the organisation.
+func Members(organisationId uuid.UUID) ([]model.OrganisationMember, error) {
+	members := []model.OrganisationMember{}
+	if err := db.DB.Preload("CustomRole").Find(&members, "organisation = ?", organisationId); err.Error != nil {
+		return nil, err.Error
+	}
+	return members, nil
+}
+
+// AssignRole gives the user a role in the organisation, adding them as a member
+// if they are not one already. The role is either the name of a built in role or
+// the ID of one of the organisation's custom roles. Nobody can grant, or take
+// away, permissions they do not hold themselves.
+func AssignRole(organisation model.Organisation, userId uuid.UUID, role string, actor *principal.Principal) (*model.OrganisationMember, error) {
+	if userId == organisation.OwnerID {
+		return nil, ErrOwnerRole
+	}
+
+	roleName, customRole, permissions, err := resolveRole(organisation.ID, role)
+	if err != nil {
+		return nil, err
+	}
+	if !canGrant(actor, organisation.ID, permissions) {
+		return nil, ErrCannotGrant
+	}
+
+	var member model.OrganisationMember
+	if result := db.DB.Preload("CustomRole").Find(&member, "organisation = ? AND user = ?", organisation.ID, userId); result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected > 0 && !canGrant(actor, organisation.ID, PermissionsOf(member)) {
+		return nil, ErrCannotGrant
+	}
+
+	return saveMember(db.DB, organisation, &member, userId, roleName, customRole)
+}
+
+// CheckAssignable reports why the actor could not give the role to someone, if
+// they couldn't.
+func CheckAssignable(organisationId uuid.UUID, role string, actor *principal.Principal) error {
+	_, _, permissions, err := resolveRole(organisationId, role)
+	if err != nil {
+		return err
+	}
+	if !canGrant(actor, organisationId, permissions) {
+		return ErrCannotGrant
+	}
+	return nil
+}
+
+// AddMember gives a user who is not yet a member a role which has already been
+// approved by someone allowed to grant it, such as through an invitation. The
+// member is saved through tx, so it can be added as part of a transaction.
+func AddMember(tx *gorm.DB, organisation model.Organisation, userId uuid.UUID, role string) (*model.OrganisationMember, error) {
+	if userId == organisation.OwnerID {
+		return nil, ErrAlreadyMember
+	}
+
+	roleName, customRole, _, err := resolveRole(organisation.ID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	var member model.OrganisationMember
+	if result := tx.Find(&member, "organisation = ? AND user = ?", organisation.ID, userId); result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected > 0 {
+		return nil, ErrAlreadyMember
+	}
+
+	return saveMember(tx, organisation, &member, userId, roleName, customRole)
+}
+
+// RemoveMember takes away the user's role in the organisation.
+func RemoveMember(organisation model.Organisation, userId uuid.UUID, actor *principal.Principal) error {
+	if userId == organisation.OwnerID {
+		return ErrOwnerRole
+	}
+
+	var member model.OrganisationMember
+	if err := db.DB.Preload("CustomRole").First(&member, "organisation = ? AND user = ?", organisation.ID, userId); err.Error != nil {
+		return ErrMemberNotFound
+	}
+	if userId != actor.UserID && !canGrant(actor, organisation.ID, PermissionsOf(member)) {
+		return ErrCannotGrant
+	}
+
+	return db.DB.Unscoped().Delete(&member).Error
+}
+
+// CustomRoles returns the roles the organisation has defined.
+func CustomRoles(organisationId uuid.UUID) ([]model.OrganisationRole, error) {
+	roles := []model.OrganisationRole{}
+	if err := db.DB.Find(&roles, "organisation = ?", organisationId); err.Error != nil {
+		return nil, err.Error
+	}
+	return roles, nil
+}
+
+// CreateRole defines a custom role for the organisation.
+func CreateRole(organisationId uuid.UUID, name string, permissions []string, actor *principal.Principal) (*model.OrganisationRole, error) {
+	if err := validateRole(name, permissions); err != nil {
+		return nil, err
+	}
+	if !canGrant(actor, organisationId, permissions) {
+		return nil, ErrCannotGrant
+	}
+
+	role := model.OrganisationRole{Organisation: organisationId, Name: strings.TrimSpace(name), Permissions: permissions}
+	if err := db.DB.Create(&role); err.Error != nil {
+		return nil, err.Error
+	}
+	return &role, nil
+}
+
+// UpdateRole changes the name and permissions of a custom role, which applies to
+// every member who has it.
+func UpdateRole(organisationId uuid.UUID, roleId uuid.UUID, name string, permissions []string, actor *principal.Principal) (*model.OrganisationRole, error) {
+	if err := validateRole(name, permissions); err != nil {
+		return nil, err
+	}
+
+	var role model.OrganisationRole
+	if err := db.DB.First(&role, "id = ? AND organisation = ?", roleId, organisationId); err.Error != nil {
+		return nil, ErrRoleNotFound
+	}
+	if !canGrant(actor, organisationId, role.Permissions) || !canGrant(actor, organisationId, permissions) {
+		return nil, ErrCannotGrant
+	}
+
+	role.Name = strings.TrimSpace(name)
+	role.Permissions = permissions
+	if err := db.DB.Save(&role); err.Error != nil {
+		return nil, err.Error
+	}
+	return &role, nil
+}
+
+// DeleteRole removes a custom role which is no longer assigned to anyone.
+func DeleteRole(organisationId uuid.UUID, roleId uuid.UUID, actor *principal.Principal) error {
+	var role model.OrganisationRole
+	if err := db.DB.First(&role, "id = ? AND organisation = ?", roleId, organisationId); err.Error != nil {
+		return ErrRoleNotFound
+	}
+	if !canGrant(actor, organisationId, role.Permissions) {
+		return ErrCannotGrant
+	}
+
+	var assigned int64
+	db.DB.Model(&model.OrganisationMember{}).Where("custom_role_id = ?", role.ID).Count(&assigned)
+	if assigned > 0 {
+		return ErrRoleInUse
+	}
+
+	return db.DB.Unscoped().Delete(&role).Error
+}
+
+func saveMember(tx *gorm.DB, organisation model.Organisation, member *model.OrganisationMember, userId uuid.UUID, roleName string, customRole *model.OrganisationRole) (*model.OrganisationMember, error) {
+	var user model.User
+	if err := tx.First(&user, "Id = ?", userId); err.Error != nil {
+		return nil, ErrUserNotFound
+	}
+	if organisation.RequireTwoFactor && !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorRequired
+	}
+
+	member.Organisation = organisation.ID
+	member.User = userId
+	member.Role = roleName
+	member.CustomRoleID = nil
+	member.CustomRole = nil
+	if customRole != nil {
+		member.CustomRoleID = &customRole.ID
+	}
+	if err := tx.Save(member); err.Error != nil {
+		return nil, err.Error
+	}
+
+	member.CustomRole = customRole
+	return member, nil
+}
+
+func resolveRole(organisationId uuid.UUID, role string) (string, *model.OrganisationRole, []string, error) {
+	if role == RoleOwner || role == RoleCustom {
+		return "", nil, nil, ErrRoleNotFound
+	}
+	if permissions, ok := BuiltInRoles[role]; ok {
+		return role, nil, permissions, nil
+	}
+
+	roleId, err := uuid.Parse(role)
+	if err != nil {
+		return "", nil, nil, ErrRoleNotFound
+	}
+	var customRole model.OrganisationRole
+	if err := db.DB.First(&customRole, "id = ? AND organisation = ?", roleId, organisationId); err.Error != nil {
+		return "", nil, nil, ErrRoleNotFound
+	}
+	return RoleCustom, &customRole, customRole.Permissions, nil
+}
+
+func validateRole(name string, permissions []string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return ErrInvalidRoleName
+	}
+	if _, ok := BuiltInRoles[strings.ToLower(name)]; ok {
+		return ErrInvalidRoleName
+	}
+	for _, permission := range permissions {
+		if !IsPermission(permission) {
+			return ErrInvalidPermission
+		}
+	}
+	return nil
+}
+
+func canGrant(actor *principal.Principal, organisationId uuid.UUID, permissions []string) bool {
+	if actor.GlobalAdmin {
+		return true
+	}
+	membership, ok := actor.RoleIn(organisationId)
+	if !ok {
+		return false
+	}
+	return IsSubset(permissions, membership.Permissions)
+}
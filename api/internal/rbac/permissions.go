@@ -0,0 +1,95 @@
+package rbac
+
+// Permissions that can be granted by an organisation role. The permissions an
+// API key may use are granted to it as scopes with the same names.
+const (
+	PermissionOrganisationRead     = "organisation:read"
+	PermissionOrganisationUpdate   = "organisation:update"
+	PermissionOrganisationDelete   = "organisation:delete"
+	PermissionOrganisationSecurity = "organisation:security"
+	PermissionMembersRead          = "members:read"
+	PermissionMembersManage        = "members:manage"
+	PermissionRolesManage          = "roles:manage"
+	PermissionAPIKeysManage        = "apikeys:manage"
+	PermissionAppealsRead          = "appeals:read"
+	PermissionAppealsRespond       = "appeals:respond"
+	PermissionTemplatesRead        = "templates:read"
+	PermissionTemplatesWrite       = "templates:write"
+)
+
+var Permissions = []string{
+	PermissionOrganisationRead,
+	PermissionOrganisationUpdate,
+	PermissionOrganisationDelete,
+	PermissionOrganisationSecurity,
+	PermissionMembersRead,
+	PermissionMembersManage,
+	PermissionRolesManage,
+	PermissionAPIKeysManage,
+	PermissionAppealsRead,
+	PermissionAppealsRespond,
+	PermissionTemplatesRead,
+	PermissionTemplatesWrite,
+}
+
+// Built in roles. Members given a custom role have RoleCustom as their role.
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleViewer    = "viewer"
+	RoleCustom    = "custom"
+)
+
+var BuiltInRoles = map[string][]string{
+	RoleOwner: Permissions,
+	RoleAdmin: {
+		PermissionOrganisationRead,
+		PermissionOrganisationUpdate,
+		PermissionOrganisationSecurity,
+		PermissionMembersRead,
+		PermissionMembersManage,
+		PermissionRolesManage,
+		PermissionAPIKeysManage,
+		PermissionAppealsRead,
+		PermissionAppealsRespond,
+		PermissionTemplatesRead,
+		PermissionTemplatesWrite,
+	},
+	RoleModerator: {
+		PermissionOrganisationRead,
+		PermissionMembersRead,
+		PermissionAppealsRead,
+		PermissionAppealsRespond,
+		PermissionTemplatesRead,
+	},
+	RoleViewer: {
+		PermissionOrganisationRead,
+		PermissionAppealsRead,
+		PermissionTemplatesRead,
+	},
+}
+
+// IsPermission reports whether the permission exists.
+func IsPermission(permission string) bool {
+	return contains(Permissions, permission)
+}
+
+// IsSubset reports whether every permission in permissions is also in of.
+func IsSubset(permissions []string, of []string) bool {
+	for _, permission := range permissions {
+		if !contains(of, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
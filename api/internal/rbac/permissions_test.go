@@ -0,0 +1,40 @@
+package rbac
+
+import "testing"
+
+func TestIsSubset(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		of          []string
+		want        bool
+	}{
+		{"empty", nil, BuiltInRoles[RoleViewer], true},
+		{"equal", BuiltInRoles[RoleModerator], BuiltInRoles[RoleModerator], true},
+		{"fewer", []string{PermissionAppealsRead}, BuiltInRoles[RoleModerator], true},
+		{"different order", []string{PermissionTemplatesRead, PermissionOrganisationRead}, BuiltInRoles[RoleViewer], true},
+		{"one more", []string{PermissionAppealsRead, PermissionAppealsRespond}, BuiltInRoles[RoleViewer], false},
+		{"of nothing", []string{PermissionAppealsRead}, nil, false},
+		{"unknown permission", []string{"appeals:*"}, Permissions, false},
+		{"admin within owner", BuiltInRoles[RoleAdmin], BuiltInRoles[RoleOwner], true},
+		{"owner within admin", BuiltInRoles[RoleOwner], BuiltInRoles[RoleAdmin], false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsSubset(test.permissions, test.of); got != test.want {
+				t.Errorf("IsSubset is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuiltInRolesArePermissions(t *testing.T) {
+	for role, permissions := range BuiltInRoles {
+		for _, permission := range permissions {
+			if !IsPermission(permission) {
+				t.Errorf("%s has unknown permission '%s'", role, permission)
+			}
+		}
+	}
+}
@@ -1,16 +1,22 @@
 package request
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
+	"github.com/benhall-1/appealscc/api/internal/authentication"
+	"github.com/benhall-1/appealscc/api/internal/domains"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/principal"
+	"github.com/benhall-1/appealscc/api/internal/tokens"
 	"github.com/getsentry/sentry-go"
-	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 )
 
 type Response struct {
@@ -18,9 +24,6 @@ type Response struct {
 	Body   interface{} `json:"body"`
 }
 
-// Create the JWT string
-var jwtKey = []byte(os.Getenv("SECRET"))
-
 func createResponse(status int, body interface{}) Response {
 	return Response{Status: status, Body: body}
 }
@@ -30,70 +33,165 @@ func Respond(w http.ResponseWriter, status int, body interface{}) error {
 	return json.NewEncoder(w).Encode(createResponse(status, body))
 }
 
-func Authorize(w http.ResponseWriter, r *http.Request) bool {
-	token := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-	if token == "" {
-		Respond(w, http.StatusUnauthorized, "Access Denied - Token not found")
-		return false
+// RespondCached responds with a body that clients and shared caches may keep for
+// maxAge, answering with 304 Not Modified when the client already has it.
+func RespondCached(w http.ResponseWriter, r *http.Request, maxAge time.Duration, body interface{}) error {
+	encoded, err := json.Marshal(createResponse(http.StatusOK, body))
+	if err != nil {
+		return err
 	}
-	claims := &authmodel.Claims{}
-	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
 
-	if err != nil {
-		sentryError := sentry.CaptureException(err)
-		if err == jwt.ErrSignatureInvalid {
-			Respond(w, http.StatusUnauthorized, fmt.Sprintf("Invalid signature for provided token. Error code '%s'", *sentryError))
-			return false
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(encoded))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if strings.TrimSpace(match) == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
 		}
-		Respond(w, http.StatusBadRequest, fmt.Sprintf("Error whilst processing token. Error code '%s'", *sentryError))
-		return false
-	}
-	if !tkn.Valid {
-		Respond(w, http.StatusUnauthorized, "Expired token - Please try logging in again")
-		return false
 	}
 
-	return true
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(append(encoded, '\n'))
+	return err
+}
+
+type routeAccess int
+
+const (
+	accessAuthenticated routeAccess = iota
+	accessAnonymous
+	accessAPIKey
+)
+
+// Routes are registered once at startup, so this is only read while serving
+var routeAccessRules = map[*mux.Route]routeAccess{}
+
+// Anonymous marks a route as usable without logging in.
+func Anonymous(route *mux.Route) *mux.Route {
+	routeAccessRules[route] = accessAnonymous
+	return route
 }
 
-func RefreshToken(w http.ResponseWriter, r *http.Request) bool {
-	token := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-	if token == "" {
+// AllowAPIKeys marks a route as usable with an API key as well as a logged in
+// user. The handler must still check the key's scope with RequireScope.
+func AllowAPIKeys(route *mux.Route) *mux.Route {
+	routeAccessRules[route] = accessAPIKey
+	return route
+}
+
+// Authenticate is the middleware which resolves the bearer token once per
+// request and stores the principal for handlers to read with CurrentPrincipal.
+// Every route requires a logged in user unless it was marked Anonymous.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		access := routeAccessRules[mux.CurrentRoute(r)]
+
+		token, found := bearerToken(r)
+		if access == accessAnonymous {
+			// Anonymous routes ignore stale or invalid tokens rather than rejecting the request
+			if found && !authentication.IsAPIKey(token) {
+				if p, err := authentication.Authenticate(token); err == nil {
+					r = r.WithContext(principal.NewContext(r.Context(), p))
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !found {
+			Respond(w, http.StatusUnauthorized, "Access Denied - Token not found")
+			return
+		}
+
+		p, err := authentication.Authenticate(token)
+		if err != nil {
+			switch err {
+			case tokens.ErrExpiredToken:
+				Respond(w, http.StatusUnauthorized, "Expired token - Please try logging in again")
+			case tokens.ErrInvalidToken:
+				Respond(w, http.StatusUnauthorized, "Access Denied - Invalid token")
+			case authentication.ErrInvalidAPIKey:
+				Respond(w, http.StatusUnauthorized, "Access Denied - API key is invalid, revoked or has expired")
+			default:
+				sentryError := sentry.CaptureException(err)
+				Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst checking your access. Error code '%s'", *sentryError))
+			}
+			return
+		}
+
+		if p.AuthMethod == principal.AuthMethodAPIKey && access != accessAPIKey {
+			Respond(w, http.StatusForbidden, "Access Denied - API keys cannot be used for this endpoint")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(principal.NewContext(r.Context(), p)))
+	})
+}
+
+type organisationContextKey struct{}
+
+// ResolveOrganisation is the middleware which finds the organisation served on
+// the request's host, for handlers to read with CurrentOrganisation.
+func ResolveOrganisation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		organisation, err := domains.Resolve(r.Host)
+		if err != nil {
+			sentryError := sentry.CaptureException(err)
+			Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst finding the organisation. Error code '%s'", *sentryError))
+			return
+		}
+		if organisation != nil {
+			r = r.WithContext(context.WithValue(r.Context(), organisationContextKey{}, organisation))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CurrentOrganisation returns the organisation served on the request's host, or
+// nil when the host does not belong to one.
+func CurrentOrganisation(r *http.Request) *model.Organisation {
+	organisation, _ := r.Context().Value(organisationContextKey{}).(*model.Organisation)
+	return organisation
+}
+
+// CurrentPrincipal returns the caller of the request, which is nil on an
+// anonymous route when nobody is logged in.
+func CurrentPrincipal(r *http.Request) *principal.Principal {
+	p, _ := principal.FromContext(r.Context())
+	return p
+}
+
+// RequirePermission checks the caller's role in the organisation grants the
+// permission, that an API key has been granted it as a scope, and that the caller
+// meets the organisation's two-factor authentication policy.
+func RequirePermission(w http.ResponseWriter, r *http.Request, organisationId uuid.UUID, permission string) bool {
+	p := CurrentPrincipal(r)
+	if p == nil {
 		Respond(w, http.StatusUnauthorized, "Access Denied - Token not found")
 		return false
 	}
-	claims := &authmodel.Claims{}
-	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil {
-		sentryError := sentry.CaptureException(err)
-		if err == jwt.ErrSignatureInvalid {
-			Respond(w, http.StatusUnauthorized, fmt.Sprintf("Invalid signature for provided token. Error code '%s'", *sentryError))
-			return false
-		}
-		Respond(w, http.StatusBadRequest, "Error whilst authenticating")
+	if !p.HasScope(organisationId, permission) {
+		Respond(w, http.StatusForbidden, fmt.Sprintf("Access Denied - API key does not have the '%s' scope for this organisation", permission))
 		return false
 	}
-	if !tkn.Valid {
-		Respond(w, http.StatusUnauthorized, "Expired token - Please try logging in again")
+	if !p.HasPermission(organisationId, permission) {
+		Respond(w, http.StatusForbidden, fmt.Sprintf("Access Denied - You do not have the '%s' permission for this organisation", permission))
 		return false
 	}
-
-	// Now, create a new token for the current use, with a renewed expiration time
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims.ExpiresAt = expirationTime.Unix()
-	nToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := nToken.SignedString(jwtKey)
-	if err != nil {
-		sentry.CaptureException(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if !p.SatisfiesTwoFactor(organisationId) {
+		Respond(w, http.StatusForbidden, "Access Denied - This organisation requires you to enable two-factor authentication")
 		return false
 	}
-
-	Respond(w, http.StatusOK, authmodel.TokenResponse{Token: tokenString, Expiration: expirationTime})
-
 	return true
 }
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	return token, token != ""
+}
@@ -0,0 +1,51 @@
+package templatedocs
+
+import (
+	"embed"
+	"errors"
+
+	"github.com/benhall-1/appealscc/api/internal/models/templatemodel"
+)
+
+//go:embed gallery/*.yaml
+var galleryFiles embed.FS
+
+var ErrStarterNotFound = errors.New("starter template not found")
+
+// Gallery lists the starter templates organisations can begin from, each read
+// from the document of the same name in the gallery directory.
+var Gallery = []templatemodel.GalleryTemplate{
+	{
+		ID:          "discord-ban",
+		Platform:    "Discord",
+		Name:        "Discord ban appeal",
+		Description: "For members banned from a Discord server, asking which rule they broke and why they should be unbanned.",
+	},
+	{
+		ID:          "twitch-ban",
+		Platform:    "Twitch",
+		Name:        "Twitch ban appeal",
+		Description: "For viewers banned or timed out in a Twitch channel's chat.",
+	},
+	{
+		ID:          "minecraft-ban",
+		Platform:    "Minecraft",
+		Name:        "Minecraft ban appeal",
+		Description: "For players banned from a Minecraft server, asking for their username and the ban reason shown when joining.",
+	},
+}
+
+// Starter returns the document of the starter template with the ID given.
+func Starter(id string) (templatemodel.TemplateDocument, error) {
+	for _, starter := range Gallery {
+		if starter.ID != id {
+			continue
+		}
+		data, err := galleryFiles.ReadFile("gallery/" + id + ".yaml")
+		if err != nil {
+			return templatemodel.TemplateDocument{}, err
+		}
+		return Decode(data, FormatYAML)
+	}
+	return templatemodel.TemplateDocument{}, ErrStarterNotFound
+}
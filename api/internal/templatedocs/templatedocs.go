@@ -0,0 +1,298 @@
+package templatedocs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/fields"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/templatemodel"
+	"github.com/benhall-1/appealscc/api/internal/publishing"
+	"github.com/benhall-1/appealscc/api/internal/versions"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Format identifies a document as an exported template.
+	Format = "appealscc/template"
+	// Version is the version of the document format written by Export. Documents
+	// written in an older version can still be imported.
+	Version = 2
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+const (
+	ConflictRename  = "rename"
+	ConflictReplace = "replace"
+	ConflictFail    = "fail"
+)
+
+// MaxDocumentSize is the largest document that will be imported.
+const MaxDocumentSize = 1024 * 1024
+
+var (
+	ErrNotTemplate        = errors.New("document is not an exported template")
+	ErrUnsupportedVersion = errors.New("document was exported by a newer version")
+	ErrUnknownFormat      = errors.New("documents are either json or yaml")
+	ErrUnknownConflict    = errors.New("conflict must be rename, replace or fail")
+	ErrNameTaken          = errors.New("organisation already has a template with this name")
+	ErrTemplateLimit      = errors.New("organisation has reached its template limit")
+)
+
+// InvalidDocumentError is returned when a document cannot be read, explaining
+// where it went wrong.
+type InvalidDocumentError struct {
+	Err error
+}
+
+func (e *InvalidDocumentError) Error() string {
+	return e.Err.Error()
+}
+
+// FormatOf picks the format of a document from the format asked for, falling
+// back to the content type and then to JSON.
+func FormatOf(format string, contentType string) (string, error) {
+	switch strings.ToLower(format) {
+	case FormatJSON, FormatYAML:
+		return strings.ToLower(format), nil
+	case "yml":
+		return FormatYAML, nil
+	case "":
+		if strings.Contains(strings.ToLower(contentType), "yaml") {
+			return FormatYAML, nil
+		}
+		return FormatJSON, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// ContentType is the content type documents of the format are served as.
+func ContentType(format string) string {
+	if format == FormatYAML {
+		return "application/yaml"
+	}
+	return "application/json"
+}
+
+// Export writes out the current version of the template, which must have been
+// loaded with versions.Preload.
+func Export(template model.AppealTemplate) (templatemodel.TemplateDocument, error) {
+	current, err := versions.Current(template)
+	if err != nil {
+		return templatemodel.TemplateDocument{}, err
+	}
+
+	document := templatemodel.TemplateDocument{
+		Format:  Format,
+		Version: Version,
+		Name:    current.Name,
+		Fields:  []templatemodel.DocumentField{},
+	}
+	for _, section := range current.Sections {
+		document.Sections = append(document.Sections, templatemodel.DocumentSection{Key: section.Key, Title: section.Title, Description: section.Description})
+	}
+	for _, field := range current.Fields {
+		document.Fields = append(document.Fields, templatemodel.DocumentField{
+			Key:            field.Key,
+			Section:        field.Section,
+			Title:          field.Title,
+			Type:           field.Type,
+			Description:    field.Description,
+			Placeholder:    field.Placeholder,
+			CharacterLimit: field.CharacterLimit,
+			Required:       field.Required,
+			Options:        field.Options,
+			Extensions:     field.Extensions,
+			Min:            field.Min,
+			Max:            field.Max,
+			Pattern:        field.Pattern,
+			ShowWhen:       exportConditions(field.ShowWhen),
+			RequireWhen:    exportConditions(field.RequireWhen),
+		})
+	}
+	return document, nil
+}
+
+// Encode writes the document in the format given.
+func Encode(document templatemodel.TemplateDocument, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		return json.MarshalIndent(document, "", "  ")
+	case FormatYAML:
+		var encoded bytes.Buffer
+		encoder := yaml.NewEncoder(&encoded)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(document); err != nil {
+			return nil, err
+		}
+		return encoded.Bytes(), encoder.Close()
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Decode reads a document in the format given, rejecting settings it does not
+// know about and documents from a newer version of the format.
+func Decode(data []byte, format string) (templatemodel.TemplateDocument, error) {
+	var document templatemodel.TemplateDocument
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&document); err != nil {
+			return document, &InvalidDocumentError{Err: err}
+		}
+	case FormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&document); err != nil {
+			return document, &InvalidDocumentError{Err: err}
+		}
+	default:
+		return document, ErrUnknownFormat
+	}
+
+	if document.Format != Format || document.Version < 1 {
+		return document, ErrNotTemplate
+	}
+	if document.Version > Version {
+		return document, ErrUnsupportedVersion
+	}
+	upgrade(&document)
+	return document, nil
+}
+
+// upgrade brings a document written in an older version of the format up to
+// date. Version 1 called file links "file", with their extensions as options.
+func upgrade(document *templatemodel.TemplateDocument) {
+	if document.Version < 2 {
+		for i, field := range document.Fields {
+			if strings.EqualFold(strings.TrimSpace(field.Type), "file") {
+				document.Fields[i].Type = fields.TypeFileLink
+				document.Fields[i].Extensions = field.Options
+				document.Fields[i].Options = nil
+			}
+		}
+	}
+	document.Version = Version
+}
+
+// Draft turns the document into the sections and questions of a template, ready
+// to be checked with fields.ValidateTemplate.
+func Draft(document templatemodel.TemplateDocument) model.AppealTemplateVersion {
+	draft := model.AppealTemplateVersion{Name: strings.TrimSpace(document.Name)}
+	for _, section := range document.Sections {
+		draft.Sections = append(draft.Sections, model.AppealTemplateSection{Key: section.Key, Title: section.Title, Description: section.Description})
+	}
+	for _, field := range document.Fields {
+		draft.Fields = append(draft.Fields, model.AppealTemplateField{
+			Key:            field.Key,
+			Section:        field.Section,
+			Title:          field.Title,
+			Type:           field.Type,
+			Description:    field.Description,
+			Placeholder:    field.Placeholder,
+			CharacterLimit: field.CharacterLimit,
+			Required:       field.Required,
+			Options:        field.Options,
+			Extensions:     field.Extensions,
+			Min:            field.Min,
+			Max:            field.Max,
+			Pattern:        field.Pattern,
+			ShowWhen:       importConditions(field.ShowWhen),
+			RequireWhen:    importConditions(field.RequireWhen),
+		})
+	}
+	return draft
+}
+
+// Import adds the draft to the organisation as a new draft template. When the
+// organisation already has a template with the same name, conflict decides
+// whether the new one is renamed, replaces the existing template as a new
+// version of it, or is not imported. Organisations with a limit can only import
+// new templates while they have fewer than that many, where 0 is no limit.
+func Import(organisationId uuid.UUID, draft model.AppealTemplateVersion, conflict string, limit int, author *uuid.UUID) (*model.AppealTemplate, error) {
+	if conflict == "" {
+		conflict = ConflictRename
+	}
+	if conflict != ConflictRename && conflict != ConflictReplace && conflict != ConflictFail {
+		return nil, ErrUnknownConflict
+	}
+
+	var existing []model.AppealTemplate
+	if err := versions.Preload(db.DB).Find(&existing, "organisation = ?", organisationId); err.Error != nil {
+		return nil, err.Error
+	}
+
+	taken := map[string]int{}
+	for i, template := range existing {
+		taken[strings.ToLower(template.Name)] = i
+	}
+	if i, ok := taken[strings.ToLower(draft.Name)]; ok {
+		switch conflict {
+		case ConflictFail:
+			return nil, ErrNameTaken
+		case ConflictReplace:
+			template := existing[i]
+			draft.Name = template.Name
+			if err := versions.Update(&template, draft, author); err != nil {
+				return nil, err
+			}
+			return &template, nil
+		case ConflictRename:
+			for n := 2; ; n++ {
+				name := fmt.Sprintf("%s (%d)", draft.Name, n)
+				if _, ok := taken[strings.ToLower(name)]; !ok {
+					draft.Name = name
+					break
+				}
+			}
+		}
+	}
+
+	inUse := 0
+	for _, template := range existing {
+		if template.Status != publishing.StatusArchived {
+			inUse++
+		}
+	}
+	if limit > 0 && inUse >= limit {
+		return nil, ErrTemplateLimit
+	}
+
+	template := model.AppealTemplate{
+		Organisation:         organisationId,
+		Status:               publishing.StatusDraft,
+		Name:                 draft.Name,
+		Sections:             draft.Sections,
+		AppealTemplateFields: draft.Fields,
+	}
+	if err := versions.CreateTemplate(&template, author); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func exportConditions(conditions model.FieldConditions) []templatemodel.DocumentCondition {
+	var exported []templatemodel.DocumentCondition
+	for _, condition := range conditions {
+		exported = append(exported, templatemodel.DocumentCondition{Field: condition.Field, Operator: condition.Operator, Value: condition.Value, Values: condition.Values})
+	}
+	return exported
+}
+
+func importConditions(conditions []templatemodel.DocumentCondition) model.FieldConditions {
+	var imported model.FieldConditions
+	for _, condition := range conditions {
+		imported = append(imported, model.FieldCondition{Field: condition.Field, Operator: condition.Operator, Value: condition.Value, Values: condition.Values})
+	}
+	return imported
+}
@@ -0,0 +1,316 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/getsentry/sentry-go"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+const (
+	// Retired keys keep verifying tokens long enough for every token they signed to expire
+	retiredKeyLifetime = time.Hour
+	rotationCheck      = time.Hour
+	// Tokens naming a key this instance has not loaded reload the keys at most this often
+	unknownKeyReload = 10 * time.Second
+	defaultRotation  = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidToken = errors.New("token is invalid")
+	ErrExpiredToken = errors.New("token has expired")
+	ErrNoSigningKey = errors.New("no signing key is available")
+)
+
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+	retired   bool
+}
+
+var (
+	mutex   sync.RWMutex
+	keys    = map[string]*signingKey{}
+	current *signingKey
+
+	reloadMutex sync.Mutex
+	lastReload  time.Time
+)
+
+// Init loads the signing keys from the database, creating the first one if none
+// exist yet. It must be called after the database has been opened.
+func Init() error {
+	if err := load(); err != nil {
+		return err
+	}
+
+	mutex.RLock()
+	needsKey := current == nil || current.method.Alg() != algorithm().Alg()
+	mutex.RUnlock()
+
+	if needsKey {
+		return Rotate()
+	}
+	return nil
+}
+
+// StartRotation periodically reloads keys created by other instances and rotates
+// the signing key once it is older than JWT_ROTATION_INTERVAL.
+func StartRotation() {
+	ticker := time.NewTicker(rotationCheck)
+	go func() {
+		for range ticker.C {
+			if err := load(); err != nil {
+				sentry.CaptureException(err)
+				continue
+			}
+
+			mutex.RLock()
+			due := current == nil || time.Since(current.createdAt) > rotationInterval()
+			mutex.RUnlock()
+
+			if due {
+				if err := Rotate(); err != nil {
+					sentry.CaptureException(err)
+				}
+			}
+		}
+	}()
+}
+
+// Rotate creates a new signing key and retires the previous ones, which remain
+// available for verification until the tokens they signed have expired.
+func Rotate() error {
+	method := algorithm()
+
+	var private crypto.Signer
+	var err error
+	switch method {
+	case jwt.SigningMethodEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(retiredKeyLifetime)
+	if err := db.DB.Model(&model.SigningKey{}).Where("retired_at IS NULL").Updates(map[string]interface{}{"retired_at": now, "expires_at": expiresAt}); err.Error != nil {
+		return err.Error
+	}
+
+	key := model.SigningKey{
+		Kid:        uuid.New().String(),
+		Algorithm:  method.Alg(),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+	if err := db.DB.Create(&key); err.Error != nil {
+		return err.Error
+	}
+
+	log.Printf("Rotated JWT signing key, now signing with '%s'", key.Kid)
+	return load()
+}
+
+// Sign signs the claims with the current key, setting the kid header so the
+// token can be verified with the matching key from the JWKS.
+func Sign(claims jwt.Claims) (string, error) {
+	mutex.RLock()
+	key := current
+	mutex.RUnlock()
+
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+type verifiableClaims interface {
+	jwt.Claims
+	VerifyIssuer(cmp string, req bool) bool
+	VerifyAudience(cmp string, req bool) bool
+}
+
+// Parse verifies the token against the key named in its kid header and checks it
+// was issued by, and for, this API.
+func Parse(tokenString string, claims verifiableClaims) (*jwt.Token, error) {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		mutex.RLock()
+		key, ok := keys[kid]
+		mutex.RUnlock()
+
+		if !ok {
+			key, ok = reloadFor(kid)
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key '%s'", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method '%s'", token.Method.Alg())
+		}
+		return key.private.Public(), nil
+	})
+	if err != nil {
+		if validationError, ok := err.(*jwt.ValidationError); ok && validationError.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+	if !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	issuer := os.Getenv("TOKEN_ISSUER")
+	audience := os.Getenv("BASE_URL")
+	if !claims.VerifyIssuer(issuer, issuer != "") || !claims.VerifyAudience(audience, audience != "") {
+		return nil, ErrInvalidToken
+	}
+
+	return token, nil
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public half of every key that tokens may currently be signed with.
+func JWKS() JSONWebKeySet {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range keys {
+		jwk := JSONWebKey{Use: "sig", Kid: key.kid, Alg: key.method.Alg()}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// reloadFor reloads the keys when a token names one this instance has not loaded,
+// as another instance may have rotated to it since. Reloads are rate limited so
+// tokens with made up kids cannot flood the database.
+func reloadFor(kid string) (*signingKey, bool) {
+	reloadMutex.Lock()
+	if time.Since(lastReload) >= unknownKeyReload {
+		lastReload = time.Now()
+		if err := load(); err != nil {
+			sentry.CaptureException(err)
+		}
+	}
+	reloadMutex.Unlock()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	key, ok := keys[kid]
+	return key, ok
+}
+
+func load() error {
+	var stored []model.SigningKey
+	if err := db.DB.Order("created_at asc").Find(&stored, "expires_at IS NULL OR expires_at > ?", time.Now()); err.Error != nil {
+		return err.Error
+	}
+
+	loaded := map[string]*signingKey{}
+	var newest *signingKey
+	for _, storedKey := range stored {
+		block, _ := pem.Decode([]byte(storedKey.PrivateKey))
+		if block == nil {
+			return fmt.Errorf("signing key '%s' is not PEM encoded", storedKey.Kid)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		private, ok := parsed.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("signing key '%s' cannot sign", storedKey.Kid)
+		}
+
+		key := &signingKey{
+			kid:       storedKey.Kid,
+			method:    jwt.GetSigningMethod(storedKey.Algorithm),
+			private:   private,
+			createdAt: storedKey.CreatedAt,
+			retired:   storedKey.RetiredAt != nil,
+		}
+		if key.method == nil {
+			return fmt.Errorf("signing key '%s' uses unsupported algorithm '%s'", storedKey.Kid, storedKey.Algorithm)
+		}
+		loaded[key.kid] = key
+		if !key.retired {
+			newest = key
+		}
+	}
+
+	mutex.Lock()
+	keys = loaded
+	current = newest
+	mutex.Unlock()
+	return nil
+}
+
+func algorithm() jwt.SigningMethod {
+	if os.Getenv("JWT_ALGORITHM") == "EdDSA" {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+func rotationInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("JWT_ROTATION_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return defaultRotation
+}
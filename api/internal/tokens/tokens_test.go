@@ -0,0 +1,146 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func newKey(t *testing.T, kid string, method jwt.SigningMethod) *signingKey {
+	key := &signingKey{kid: kid, method: method, createdAt: time.Now()}
+	switch method {
+	case jwt.SigningMethodEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.private = private
+	default:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.private = private
+	}
+	return key
+}
+
+// useKeys replaces the loaded keys for the test. The last reload is set to now so
+// tokens with unknown kids never reach the database.
+func useKeys(t *testing.T, signWith *signingKey, set ...*signingKey) {
+	mutex.Lock()
+	previousKeys, previousCurrent, previousReload := keys, current, lastReload
+	keys = map[string]*signingKey{}
+	for _, key := range set {
+		keys[key.kid] = key
+	}
+	current = signWith
+	lastReload = time.Now()
+	mutex.Unlock()
+
+	t.Cleanup(func() {
+		mutex.Lock()
+		keys, current, lastReload = previousKeys, previousCurrent, previousReload
+		mutex.Unlock()
+	})
+}
+
+func signWith(t *testing.T, key *signingKey, method jwt.SigningMethod, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestParse(t *testing.T) {
+	t.Setenv("TOKEN_ISSUER", "https://appeals.cc")
+	t.Setenv("BASE_URL", "https://api.appeals.cc")
+
+	active := newKey(t, "active", jwt.SigningMethodRS256)
+	retired := newKey(t, "retired", jwt.SigningMethodEdDSA)
+	retired.retired = true
+	unknown := newKey(t, "unknown", jwt.SigningMethodRS256)
+	impostor := newKey(t, "active", jwt.SigningMethodRS256)
+	useKeys(t, active, active, retired)
+
+	claims := func(change func(*jwt.StandardClaims)) jwt.StandardClaims {
+		claims := jwt.StandardClaims{
+			Subject:   "user",
+			Issuer:    "https://appeals.cc",
+			Audience:  "https://api.appeals.cc",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		}
+		if change != nil {
+			change(&claims)
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"active key", signWith(t, active, jwt.SigningMethodRS256, claims(nil)), nil},
+		{"retired key", signWith(t, retired, jwt.SigningMethodEdDSA, claims(nil)), nil},
+		{"unknown kid", signWith(t, unknown, jwt.SigningMethodRS256, claims(nil)), ErrInvalidToken},
+		{"known kid signed by another key", signWith(t, impostor, jwt.SigningMethodRS256, claims(nil)), ErrInvalidToken},
+		{"algorithm does not match the key", signWith(t, active, jwt.SigningMethodRS512, claims(nil)), ErrInvalidToken},
+		{"wrong issuer", signWith(t, active, jwt.SigningMethodRS256, claims(func(c *jwt.StandardClaims) { c.Issuer = "https://example.com" })), ErrInvalidToken},
+		{"missing issuer", signWith(t, active, jwt.SigningMethodRS256, claims(func(c *jwt.StandardClaims) { c.Issuer = "" })), ErrInvalidToken},
+		{"wrong audience", signWith(t, active, jwt.SigningMethodRS256, claims(func(c *jwt.StandardClaims) { c.Audience = "https://example.com" })), ErrInvalidToken},
+		{"missing audience", signWith(t, active, jwt.SigningMethodRS256, claims(func(c *jwt.StandardClaims) { c.Audience = "" })), ErrInvalidToken},
+		{"expired", signWith(t, active, jwt.SigningMethodRS256, claims(func(c *jwt.StandardClaims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() })), ErrExpiredToken},
+		{"not a token", "not.a.token", ErrInvalidToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var parsed jwt.StandardClaims
+			_, err := Parse(test.token, &parsed)
+			if err != test.want {
+				t.Fatalf("error is %v, want %v", err, test.want)
+			}
+			if err == nil && parsed.Subject != "user" {
+				t.Errorf("subject is %q, want %q", parsed.Subject, "user")
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	t.Setenv("TOKEN_ISSUER", "")
+	t.Setenv("BASE_URL", "")
+	key := newKey(t, "active", jwt.SigningMethodEdDSA)
+	useKeys(t, key, key)
+
+	signed, err := Sign(jwt.StandardClaims{Subject: "user", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.StandardClaims
+	token, err := Parse(signed, &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != "active" || token.Method != jwt.SigningMethodEdDSA {
+		t.Errorf("signed with kid %v and %s, want kid %q and %s", token.Header["kid"], token.Method.Alg(), "active", jwt.SigningMethodEdDSA.Alg())
+	}
+}
+
+func TestSignWithoutKey(t *testing.T) {
+	useKeys(t, nil)
+
+	if _, err := Sign(jwt.StandardClaims{Subject: "user"}); err != ErrNoSigningKey {
+		t.Errorf("error is %v, want %v", err, ErrNoSigningKey)
+	}
+}
@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA1 secret from RFC 6238, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The RFC's test vectors are eight digits, so these are their last six
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		t.Run(time.Unix(test.time, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			got, err := Code(rfcSecret, test.time/period)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("code is %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("got a code for a secret that is not base32")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / period
+	code := func(step int64) string {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		secret string
+		step   int64
+		valid  bool
+	}{
+		{"current step", code(step), rfcSecret, step, true},
+		{"previous step", code(step - 1), rfcSecret, step - 1, true},
+		{"next step", code(step + 1), rfcSecret, step + 1, true},
+		{"two steps behind", code(step - 2), rfcSecret, 0, false},
+		{"two steps ahead", code(step + 2), rfcSecret, 0, false},
+		{"spaces are ignored", " " + code(step)[:3] + " " + code(step)[3:] + " ", rfcSecret, step, true},
+		{"lowercase secret", code(step), "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", step, true},
+		{"too short", code(step)[:5], rfcSecret, 0, false},
+		{"too long", code(step) + "0", rfcSecret, 0, false},
+		{"wrong code", "000000", rfcSecret, 0, false},
+		{"invalid secret", code(step), "not base32!", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, valid := Validate(test.code, test.secret, now)
+			if valid != test.valid || step != test.step {
+				t.Errorf("got step %d and valid %v, want step %d and valid %v", step, valid, test.step, test.valid)
+			}
+		})
+	}
+}
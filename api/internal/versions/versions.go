@@ -0,0 +1,335 @@
+package versions
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/templatemodel"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrVersionNotFound = errors.New("template version not found")
+	ErrAlreadyCurrent  = errors.New("this is already the current version")
+	ErrNoVersion       = errors.New("template has no current version")
+	ErrInvalidOrder    = errors.New("the new order must list every section and question exactly once")
+)
+
+// Preload loads the sections and questions of each template's current version,
+// in order, which are then available as its Sections and AppealTemplateFields.
+func Preload(query *gorm.DB) *gorm.DB {
+	return query.Preload("Versions", "current = ?", true).Preload("Versions.Sections", ordered).Preload("Versions.Fields", ordered)
+}
+
+func ordered(query *gorm.DB) *gorm.DB {
+	return query.Order("position asc, created_at asc")
+}
+
+// Current returns the template's current version, which must have been loaded
+// with Preload.
+func Current(template model.AppealTemplate) (*model.AppealTemplateVersion, error) {
+	for _, version := range template.Versions {
+		if version.Current {
+			return &version, nil
+		}
+	}
+	return nil, ErrNoVersion
+}
+
+// CreateTemplate creates the template with its sections and questions as the
+// first version.
+func CreateTemplate(template *model.AppealTemplate, author *uuid.UUID) error {
+	draft := model.AppealTemplateVersion{Name: template.Name, Sections: template.Sections, Fields: template.AppealTemplateFields}
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		template.Version = 0
+		if err := tx.Create(template); err.Error != nil {
+			return err.Error
+		}
+		_, err := create(tx, template, draft, author, nil)
+		return err
+	})
+}
+
+// Update replaces the template's name, sections and questions with those of the
+// draft as a new version. Nothing changes if they are the same as the current
+// version's. Sections and questions are kept in the order they are listed.
+func Update(template *model.AppealTemplate, draft model.AppealTemplateVersion, author *uuid.UUID) error {
+	current, err := Current(*template)
+	if err != nil {
+		return err
+	}
+	draft.Number = current.Number
+	if diff := Diff(*current, withPositions(draft)); diff.Name == nil && diff.Sections == nil && len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0 {
+		return nil
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := create(tx, template, draft, author, nil)
+		return err
+	})
+}
+
+// Reorder moves the template's sections and questions into the order given as a
+// new version, also moving questions between sections.
+func Reorder(template *model.AppealTemplate, order templatemodel.ReorderRequest, author *uuid.UUID) error {
+	current, err := Current(*template)
+	if err != nil {
+		return err
+	}
+	if len(order.Sections) != len(current.Sections) || len(order.Fields) != len(current.Fields) {
+		return ErrInvalidOrder
+	}
+
+	sections := map[string]model.AppealTemplateSection{}
+	for _, section := range current.Sections {
+		sections[section.Key] = section
+	}
+	fields := map[string]model.AppealTemplateField{}
+	for _, field := range current.Fields {
+		fields[field.Key] = field
+	}
+
+	draft := model.AppealTemplateVersion{Name: current.Name}
+	for _, key := range order.Sections {
+		section, ok := sections[key]
+		if !ok {
+			return ErrInvalidOrder
+		}
+		delete(sections, key)
+		draft.Sections = append(draft.Sections, section)
+	}
+	for _, placement := range order.Fields {
+		field, ok := fields[placement.Key]
+		if !ok {
+			return ErrInvalidOrder
+		}
+		delete(fields, placement.Key)
+		if len(draft.Sections) > 0 {
+			if _, ok := findSection(draft.Sections, placement.Section); !ok {
+				return ErrInvalidOrder
+			}
+			field.Section = placement.Section
+		}
+		draft.Fields = append(draft.Fields, field)
+	}
+
+	return Update(template, draft, author)
+}
+
+// Rollback makes a new version with the name and questions of an earlier one.
+func Rollback(template *model.AppealTemplate, number int, author *uuid.UUID) error {
+	if number == template.Version {
+		return ErrAlreadyCurrent
+	}
+	previous, err := Get(template.ID, strconv.Itoa(number))
+	if err != nil {
+		return err
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := create(tx, template, *previous, author, &previous.Number)
+		return err
+	})
+}
+
+// List returns every version of the template without their questions, newest
+// first.
+func List(templateId uuid.UUID) ([]model.AppealTemplateVersion, error) {
+	versions := []model.AppealTemplateVersion{}
+	if err := db.DB.Order("number desc").Find(&versions, "template = ?", templateId); err.Error != nil {
+		return nil, err.Error
+	}
+	return versions, nil
+}
+
+// Get returns a version of the template with its questions. The version is
+// either its number or its ID, as appeals refer to the version by ID.
+func Get(templateId uuid.UUID, version string) (*model.AppealTemplateVersion, error) {
+	query := db.DB.Preload("Sections", ordered).Preload("Fields", ordered).Where("template = ?", templateId)
+
+	if number, err := strconv.Atoi(version); err == nil {
+		query = query.Where("number = ?", number)
+	} else if versionId, err := uuid.Parse(version); err == nil {
+		query = query.Where("id = ?", versionId)
+	} else {
+		return nil, ErrVersionNotFound
+	}
+
+	var found model.AppealTemplateVersion
+	if err := query.First(&found); err.Error != nil {
+		return nil, ErrVersionNotFound
+	}
+	return &found, nil
+}
+
+// Diff lists how the questions changed from one version to another.
+func Diff(from model.AppealTemplateVersion, to model.AppealTemplateVersion) templatemodel.VersionDiff {
+	diff := templatemodel.VersionDiff{
+		From:    from.Number,
+		To:      to.Number,
+		Added:   []model.AppealTemplateField{},
+		Removed: []model.AppealTemplateField{},
+		Changed: []templatemodel.FieldChange{},
+	}
+	if from.Name != to.Name {
+		diff.Name = &templatemodel.ValueChange{Before: from.Name, After: to.Name}
+	}
+	if before, after := sectionSummary(from.Sections), sectionSummary(to.Sections); !same(before, after) {
+		diff.Sections = &templatemodel.ValueChange{Before: before, After: after}
+	}
+
+	before := map[string]model.AppealTemplateField{}
+	for _, field := range from.Fields {
+		before[field.Key] = field
+	}
+	after := map[string]bool{}
+	for _, field := range to.Fields {
+		after[field.Key] = true
+		previous, ok := before[field.Key]
+		if !ok {
+			diff.Added = append(diff.Added, field)
+		} else if changes := compare(previous, field); len(changes) > 0 {
+			diff.Changed = append(diff.Changed, templatemodel.FieldChange{Key: field.Key, Changes: changes})
+		}
+	}
+	for _, field := range from.Fields {
+		if !after[field.Key] {
+			diff.Removed = append(diff.Removed, field)
+		}
+	}
+
+	return diff
+}
+
+// settings are the parts of a question compared between versions.
+var settings = map[string]func(field model.AppealTemplateField) interface{}{
+	"Title":          func(field model.AppealTemplateField) interface{} { return field.Title },
+	"Type":           func(field model.AppealTemplateField) interface{} { return field.Type },
+	"CharacterLimit": func(field model.AppealTemplateField) interface{} { return field.CharacterLimit },
+	"Required":       func(field model.AppealTemplateField) interface{} { return field.Required },
+	"Options":        func(field model.AppealTemplateField) interface{} { return field.Options },
+	"Extensions":     func(field model.AppealTemplateField) interface{} { return field.Extensions },
+	"Min":            func(field model.AppealTemplateField) interface{} { return field.Min },
+	"Max":            func(field model.AppealTemplateField) interface{} { return field.Max },
+	"Pattern":        func(field model.AppealTemplateField) interface{} { return field.Pattern },
+	"ShowWhen":       func(field model.AppealTemplateField) interface{} { return field.ShowWhen },
+	"RequireWhen":    func(field model.AppealTemplateField) interface{} { return field.RequireWhen },
+	"Description":    func(field model.AppealTemplateField) interface{} { return field.Description },
+	"Placeholder":    func(field model.AppealTemplateField) interface{} { return field.Placeholder },
+	"Section":        func(field model.AppealTemplateField) interface{} { return field.Section },
+	"Position":       func(field model.AppealTemplateField) interface{} { return field.Position },
+}
+
+// sectionSummary is what is compared of a version's sections, ignoring the IDs
+// which are different in every version.
+func sectionSummary(sections []model.AppealTemplateSection) []templatemodel.PublicTemplateSection {
+	summary := []templatemodel.PublicTemplateSection{}
+	for _, section := range sections {
+		summary = append(summary, templatemodel.NewPublicTemplateSection(section))
+	}
+	return summary
+}
+
+func findSection(sections []model.AppealTemplateSection, key string) (model.AppealTemplateSection, bool) {
+	for _, section := range sections {
+		if section.Key == key {
+			return section, true
+		}
+	}
+	return model.AppealTemplateSection{}, false
+}
+
+// withPositions numbers the draft's sections and questions in the order they
+// are listed.
+func withPositions(draft model.AppealTemplateVersion) model.AppealTemplateVersion {
+	sections := make([]model.AppealTemplateSection, len(draft.Sections))
+	for i, section := range draft.Sections {
+		section.Position = i
+		sections[i] = section
+	}
+	fields := make([]model.AppealTemplateField, len(draft.Fields))
+	for i, field := range draft.Fields {
+		field.Position = i
+		fields[i] = field
+	}
+	draft.Sections = sections
+	draft.Fields = fields
+	return draft
+}
+
+func compare(before model.AppealTemplateField, after model.AppealTemplateField) map[string]templatemodel.ValueChange {
+	changes := map[string]templatemodel.ValueChange{}
+	for name, value := range settings {
+		if !same(value(before), value(after)) {
+			changes[name] = templatemodel.ValueChange{Before: value(before), After: value(after)}
+		}
+	}
+	return changes
+}
+
+// same compares settings by how they are stored, so an empty list is the same
+// as no list at all.
+func same(a interface{}, b interface{}) bool {
+	encodedA, _ := json.Marshal(a)
+	encodedB, _ := json.Marshal(b)
+	if string(encodedA) == "null" || string(encodedA) == "[]" {
+		return string(encodedB) == "null" || string(encodedB) == "[]"
+	}
+	return string(encodedA) == string(encodedB)
+}
+
+func create(tx *gorm.DB, template *model.AppealTemplate, draft model.AppealTemplateVersion, author *uuid.UUID, restoredFrom *int) (*model.AppealTemplateVersion, error) {
+	draft = withPositions(draft)
+	version := model.AppealTemplateVersion{
+		Template:     template.ID,
+		Number:       template.Version + 1,
+		Current:      true,
+		Name:         draft.Name,
+		CreatedBy:    author,
+		RestoredFrom: restoredFrom,
+	}
+	if err := tx.Model(&model.AppealTemplateVersion{}).Where("template = ? AND current = ?", template.ID, true).Update("current", false); err.Error != nil {
+		return nil, err.Error
+	}
+	if err := tx.Create(&version); err.Error != nil {
+		return nil, err.Error
+	}
+
+	// Sections and questions are copied so that the previous version keeps its own
+	for _, section := range draft.Sections {
+		section.Base = model.Base{}
+		section.Version = version.ID
+		version.Sections = append(version.Sections, section)
+	}
+	for _, field := range draft.Fields {
+		field.Base = model.Base{}
+		field.Template = template.ID
+		field.Version = version.ID
+		field.AppealAnswers = nil
+		version.Fields = append(version.Fields, field)
+	}
+	if len(version.Sections) > 0 {
+		if err := tx.Create(&version.Sections); err.Error != nil {
+			return nil, err.Error
+		}
+	}
+	if len(version.Fields) > 0 {
+		if err := tx.Create(&version.Fields); err.Error != nil {
+			return nil, err.Error
+		}
+	}
+	if err := tx.Model(template).Updates(map[string]interface{}{"name": version.Name, "version": version.Number}); err.Error != nil {
+		return nil, err.Error
+	}
+
+	template.Name = version.Name
+	template.Version = version.Number
+	template.Versions = []model.AppealTemplateVersion{version}
+	template.Sections = version.Sections
+	template.AppealTemplateFields = version.Fields
+	return &version, nil
+}
@@ -0,0 +1,121 @@
+package versions
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/templatemodel"
+	"github.com/google/uuid"
+)
+
+func TestDiff(t *testing.T) {
+	question := func(key string, title string) model.AppealTemplateField {
+		return model.AppealTemplateField{Base: model.Base{ID: uuid.New()}, Key: key, Title: title, Type: "short_text"}
+	}
+	section := model.AppealTemplateSection{Base: model.Base{ID: uuid.New()}, Key: "about", Title: "About you"}
+	renamed := question("name", "Your name")
+	renamed.Required = true
+
+	tests := []struct {
+		name     string
+		from     model.AppealTemplateVersion
+		to       model.AppealTemplateVersion
+		added    []string
+		removed  []string
+		changed  map[string][]string
+		renamed  bool
+		sections bool
+	}{
+		{
+			name: "no changes, even with new IDs",
+			from: model.AppealTemplateVersion{Name: "Ban", Sections: []model.AppealTemplateSection{section}, Fields: []model.AppealTemplateField{question("name", "Name")}},
+			to:   model.AppealTemplateVersion{Name: "Ban", Sections: []model.AppealTemplateSection{{Base: model.Base{ID: uuid.New()}, Key: "about", Title: "About you"}}, Fields: []model.AppealTemplateField{question("name", "Name")}},
+		},
+		{
+			name:  "question added",
+			from:  model.AppealTemplateVersion{Fields: []model.AppealTemplateField{question("name", "Name")}},
+			to:    model.AppealTemplateVersion{Fields: []model.AppealTemplateField{question("name", "Name"), question("reason", "Reason")}},
+			added: []string{"reason"},
+		},
+		{
+			name:    "question removed",
+			from:    model.AppealTemplateVersion{Fields: []model.AppealTemplateField{question("name", "Name"), question("reason", "Reason")}},
+			to:      model.AppealTemplateVersion{Fields: []model.AppealTemplateField{question("reason", "Reason")}},
+			removed: []string{"name"},
+		},
+		{
+			name:    "question changed",
+			from:    model.AppealTemplateVersion{Fields: []model.AppealTemplateField{question("name", "Name")}},
+			to:      model.AppealTemplateVersion{Fields: []model.AppealTemplateField{renamed}},
+			changed: map[string][]string{"name": {"Required", "Title"}},
+		},
+		{
+			name:    "key changed",
+			from:    model.AppealTemplateVersion{Fields: []model.AppealTemplateField{question("name", "Name")}},
+			to:      model.AppealTemplateVersion{Fields: []model.AppealTemplateField{question("username", "Name")}},
+			added:   []string{"username"},
+			removed: []string{"name"},
+		},
+		{
+			name:     "name and sections changed",
+			from:     model.AppealTemplateVersion{Name: "Ban", Sections: []model.AppealTemplateSection{section}},
+			to:       model.AppealTemplateVersion{Name: "Ban appeal"},
+			renamed:  true,
+			sections: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff := Diff(test.from, test.to)
+
+			if got := keys(diff.Added); !reflect.DeepEqual(got, orEmpty(test.added)) {
+				t.Errorf("added %v, want %v", got, test.added)
+			}
+			if got := keys(diff.Removed); !reflect.DeepEqual(got, orEmpty(test.removed)) {
+				t.Errorf("removed %v, want %v", got, test.removed)
+			}
+			want := test.changed
+			if want == nil {
+				want = map[string][]string{}
+			}
+			if got := changed(diff.Changed); !reflect.DeepEqual(got, want) {
+				t.Errorf("changed %v, want %v", got, test.changed)
+			}
+			if (diff.Name != nil) != test.renamed {
+				t.Errorf("name change is %v, want a change: %v", diff.Name, test.renamed)
+			}
+			if (diff.Sections != nil) != test.sections {
+				t.Errorf("sections change is %v, want a change: %v", diff.Sections, test.sections)
+			}
+		})
+	}
+}
+
+func keys(fields []model.AppealTemplateField) []string {
+	found := []string{}
+	for _, field := range fields {
+		found = append(found, field.Key)
+	}
+	return found
+}
+
+func changed(changes []templatemodel.FieldChange) map[string][]string {
+	found := map[string][]string{}
+	for _, change := range changes {
+		for setting := range change.Changes {
+			found[change.Key] = append(found[change.Key], setting)
+		}
+		sort.Strings(found[change.Key])
+	}
+	return found
+}
+
+func orEmpty(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/urfave/negroni"
 
 	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/lifecycle"
+	"github.com/benhall-1/appealscc/api/internal/tokens"
 	"github.com/benhall-1/appealscc/api/routing"
 )
 
@@ -38,8 +40,18 @@ func handleRequests() {
 func main() {
 	godotenv.Load()
 
-	db.Open()
-	db.Migrate()
+	if err := db.Open(); err != nil {
+		log.Fatalf("Could not connect to the database: %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Could not migrate the database: %v", err)
+	}
+
+	if err := tokens.Init(); err != nil {
+		log.Fatalf("Could not load JWT signing keys: %v", err)
+	}
+	tokens.StartRotation()
+	lifecycle.StartExpiry()
 
 	fmt.Println("AppealsCC API Server")
 	handleRequests()
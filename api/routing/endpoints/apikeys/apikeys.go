@@ -0,0 +1,116 @@
+package apikeys
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/authentication"
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func GetMyAPIKeys(w http.ResponseWriter, r *http.Request) {
+	currentUserId := request.CurrentPrincipal(r).UserID
+
+	apiKeys := []model.APIKey{}
+	if err := db.DB.Find(&apiKeys, "user = ? AND revoked_at IS NULL", currentUserId); err.Error != nil {
+		sentryError := sentry.CaptureException(err.Error)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting API keys. Error code '%s'", *sentryError))
+	} else {
+		request.Respond(w, http.StatusOK, apiKeys)
+	}
+}
+
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var createRequest authmodel.CreateAPIKeyRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&createRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		currentUser := request.CurrentPrincipal(r)
+		currentUserId := currentUser.UserID
+
+		if !request.RequirePermission(w, r, createRequest.Organisation, rbac.PermissionAPIKeysManage) {
+			return
+		}
+		for _, scope := range createRequest.Scopes {
+			if rbac.IsPermission(scope) && !currentUser.HasPermission(createRequest.Organisation, scope) {
+				request.Respond(w, http.StatusForbidden, fmt.Sprintf("Access Denied - You cannot grant the '%s' scope as you do not have that permission", scope))
+				return
+			}
+		}
+
+		key, apiKey, err := authentication.CreateAPIKey(currentUserId, createRequest.Organisation, createRequest.Name, createRequest.Scopes, createRequest.ExpiresAt)
+		if err != nil {
+			if err == authentication.ErrInvalidScope {
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid scopes - API keys can be granted %v", authentication.APIKeyScopes))
+			} else {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating API key. Error code '%s'", *sentryError))
+			}
+		} else {
+			request.Respond(w, http.StatusOK, authmodel.CreateAPIKeyResponse{Key: key, APIKey: *apiKey})
+		}
+	}
+}
+
+func RevokeMyAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyId, _ := uuid.Parse(vars["keyId"])
+	currentUserId := request.CurrentPrincipal(r).UserID
+
+	if err := authentication.RevokeAPIKey(keyId, nil, &currentUserId); err != nil {
+		if err == authentication.ErrAPIKeyNotFound {
+			request.Respond(w, http.StatusNotFound, "API key not found")
+		} else {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst revoking API key. Error code '%s'", *sentryError))
+		}
+	} else {
+		request.Respond(w, http.StatusOK, "API key revoked")
+	}
+}
+
+func GetOrganisationAPIKeys(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionAPIKeysManage) {
+		apiKeys := []model.APIKey{}
+		if err := db.DB.Find(&apiKeys, "organisation = ? AND revoked_at IS NULL", organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting API keys. Error code '%s'", *sentryError))
+		} else {
+			request.Respond(w, http.StatusOK, apiKeys)
+		}
+	}
+}
+
+func RevokeOrganisationAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	keyId, _ := uuid.Parse(vars["keyId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionAPIKeysManage) {
+		if err := authentication.RevokeAPIKey(keyId, &organisationId, nil); err != nil {
+			if err == authentication.ErrAPIKeyNotFound {
+				request.Respond(w, http.StatusNotFound, "API key not found")
+			} else {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst revoking API key. Error code '%s'", *sentryError))
+			}
+		} else {
+			request.Respond(w, http.StatusOK, "API key revoked")
+		}
+	}
+}
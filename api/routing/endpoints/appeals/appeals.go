@@ -4,130 +4,351 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/benhall-1/appealscc/api/internal/answers"
 	"github.com/benhall-1/appealscc/api/internal/authentication"
 	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/lifecycle"
+	"github.com/benhall-1/appealscc/api/internal/models/appealmodel"
 	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/principal"
+	"github.com/benhall-1/appealscc/api/internal/publishing"
+	"github.com/benhall-1/appealscc/api/internal/queue"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
 	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/benhall-1/appealscc/api/internal/versions"
 	"github.com/getsentry/sentry-go"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 func GetAllAppealsForOrganisation(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["organisationId"])
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+	currentUser := request.CurrentPrincipal(r)
 
-		appeals := []model.Appeal{}
+	// Appellants who cannot read the organisation's appeals can still list their own
+	ownOnly := currentUser != nil && currentUser.AuthMethod == principal.AuthMethodToken && !currentUser.HasPermission(organisationId, rbac.PermissionAppealsRead)
+	if !ownOnly && !request.RequirePermission(w, r, organisationId, rbac.PermissionAppealsRead) {
+		return
+	}
 
-		if err := db.DB.Find(&appeals, "Organisation = ?", organisationId); err.Error != nil {
-			sentryError := sentry.CaptureException(err.Error)
-			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting Appeals. Error code '%s'", *sentryError))
+	if query, err := queue.Parse(r.URL.Query()); err != nil {
+		respondWithQueueError(w, err)
+	} else {
+		if ownOnly {
+			query.Creator = &currentUser.UserID
+		}
+		if page, err := queue.List(organisationId, query); err != nil {
+			respondWithQueueError(w, err)
 		} else {
-			request.Respond(w, http.StatusOK, appeals)
+			request.Respond(w, http.StatusOK, page)
 		}
 	}
 }
 
 func GetSingleAppeal(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+	appealId, _ := uuid.Parse(vars["appealId"])
+	currentUser := request.CurrentPrincipal(r)
+
+	appeal := model.Appeal{}
+	found := db.DB.First(&appeal, "Id = ? AND Organisation = ?", appealId, organisationId).Error == nil
+
+	// Appellants can always see their own appeals
+	if found && isAppellant(currentUser, appeal) {
+		request.Respond(w, http.StatusOK, appeal)
+	} else if !request.RequirePermission(w, r, organisationId, rbac.PermissionAppealsRead) {
+		return
+	} else if !found {
+		request.Respond(w, http.StatusNotFound, "Appeal not found")
+	} else {
+		request.Respond(w, http.StatusOK, appeal)
+	}
+}
+
+func CreateAppeal(w http.ResponseWriter, r *http.Request) {
+	var body model.Appeal
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&body); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
 		vars := mux.Vars(r)
+
 		organisationId, _ := uuid.Parse(vars["organisationId"])
-		appealId, _ := uuid.Parse(vars["appealId"])
+		currentUserId := request.CurrentPrincipal(r).UserID
 
-		appeal := model.Appeal{}
+		var tempOrg model.Organisation
+		var tempAppealTemplate model.AppealTemplate
+		var tempAppeals []model.Appeal
 
-		if err := db.DB.First(&appeal, "Id = ? AND Organisation = ?", appealId, organisationId); err.Error != nil {
+		var currentUser model.User
+
+		if err := db.DB.First(&currentUser, "Id = ?", currentUserId); err.Error != nil {
 			sentryError := sentry.CaptureException(err.Error)
-			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Appeal not found. Error code '%s'", *sentryError))
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("User not found. Error code '%s'", *sentryError))
+		} else if authentication.EmailVerificationRequired() && !currentUser.EmailVerified {
+			request.Respond(w, http.StatusForbidden, "🚫 Please add and verify an email address before submitting an appeal")
+		} else if err := db.DB.First(&tempOrg, "Id = ?", &organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
 		} else {
-			request.Respond(w, http.StatusOK, appeal)
+			if err := versions.Preload(db.DB).First(&tempAppealTemplate, "Id = ? AND organisation = ? ", body.Template, organisationId); err.Error != nil {
+				sentryError := sentry.CaptureException(err.Error)
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Template not found. Error code '%s'", *sentryError))
+			} else if err := publishing.AcceptingAppeals(tempAppealTemplate, time.Now()); err != nil {
+				respondWithPublishingError(w, err, tempAppealTemplate)
+			} else {
+				if err := db.DB.Where("status IN ?", lifecycle.OpenStatuses).Find(&tempAppeals, "creator = ? AND template = ?", currentUserId, body.Template); err.Error != nil {
+					sentryError := sentry.CaptureException(err.Error)
+					request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Appeal creation failed. Error code '%s'", *sentryError))
+				} else if len(tempAppeals) > 0 {
+					request.Respond(w, http.StatusBadRequest, "Appeal creation failed - You already have an open appeal for this form")
+				} else if version, err := versions.Current(tempAppealTemplate); err != nil {
+					sentryError := sentry.CaptureException(err)
+					request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Appeal creation failed. Error code '%s'", *sentryError))
+				} else {
+					// Only the answers come from the appellant, everything else is set here
+					// Appeals are pinned to the version they were answered against, so later
+					// edits to the template cannot change what the questions were
+					appeal := model.Appeal{
+						Organisation:    organisationId,
+						Creator:         currentUserId,
+						Template:        tempAppealTemplate.ID,
+						TemplateVersion: version.ID,
+						Content:         body.Content,
+					}
+					for _, answer := range body.AppealAnswers {
+						appeal.AppealAnswers = append(appeal.AppealAnswers, model.AppealAnswer{Field: answer.Field, Content: answer.Content})
+					}
+
+					if errs := answers.Validate(tempAppealTemplate, appeal.Content, appeal.AppealAnswers); len(errs) > 0 {
+						request.Respond(w, http.StatusUnprocessableEntity, appealmodel.ValidationErrorResponse{Message: "😢 Some of your answers need changing before the appeal can be submitted", Errors: errs})
+					} else if err := lifecycle.Submit(&appeal); err != nil {
+						sentryError := sentry.CaptureException(err)
+						request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Appeal creation failed. Error code '%s'", *sentryError))
+					} else {
+						request.Respond(w, http.StatusOK, appeal)
+					}
+				}
+			}
 		}
 	}
 }
 
-func CreateAppeal(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
+func AddAppealResponse(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionAppealsRespond) {
+		appealId, _ := uuid.Parse(vars["appealId"])
+
+		// Permissions are per organisation, so the appeal must belong to the one being checked
 		var appeal model.Appeal
+		if err := db.DB.First(&appeal, "Id = ? AND Organisation = ?", appealId, organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusNotFound, fmt.Sprintf("Appeal not found. Error code '%s'", *sentryError))
+			return
+		}
+
+		var responseRequest appealmodel.ResponseRequest
 		decoder := json.NewDecoder(r.Body)
-		if err := decoder.Decode(&appeal); err != nil {
+		if err := decoder.Decode(&responseRequest); err != nil {
 			sentryError := sentry.CaptureException(err)
 			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
 		} else {
 			defer r.Body.Close()
-			vars := mux.Vars(r)
 
-			organisationId, _ := uuid.Parse(vars["organisationId"])
-			currentUserId, _ := uuid.Parse(authentication.GetCurrentUser(w, r)["Id"].(string))
-
-			var tempOrg model.Organisation
-			var tempAppealTemplate model.AppealTemplate
-			var tempAppeal *model.Appeal
-
-			if err := db.DB.Find(&tempOrg, "Id = ?", &organisationId); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+			if appealResponse, err := lifecycle.Respond(&appeal, request.CurrentPrincipal(r).UserID, responseRequest.Content, responseRequest.Status); err != nil {
+				respondWithStatusError(w, err)
 			} else {
-				if err := db.DB.Find(&tempAppealTemplate, "Id = ? AND organisation = ? ", appeal.Template, organisationId); err.Error != nil {
-					sentryError := sentry.CaptureException(err.Error)
-					request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Template not found. Error code '%s'", *sentryError))
-				} else {
-					if err := db.DB.First(&tempAppeal, "creator = ? AND template = ? AND appeal_status = 0", appeal.Template, organisationId); err.Error != nil && tempAppeal != nil {
-						sentryError := sentry.CaptureException(err.Error)
-						request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Appeal creation failed - You already have an open appeal for this form. Error code '%s'", *sentryError))
-					} else {
-						appeal.Creator = currentUserId
-						appeal.Organisation = organisationId
-						if err := db.DB.Create(&appeal); err.Error != nil {
-							sentryError := sentry.CaptureException(err.Error)
-							request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Appeal creation failed. Error code '%s'", *sentryError))
-						} else {
-							request.Respond(w, http.StatusOK, appeal)
-						}
-					}
-				}
+				request.Respond(w, http.StatusOK, appealResponse)
 			}
 		}
 	}
 }
 
-func AddAppealResponse(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
+func TransitionAppeal(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+	appealId, _ := uuid.Parse(vars["appealId"])
+	currentUser := request.CurrentPrincipal(r)
+
+	var transitionRequest appealmodel.TransitionRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&transitionRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		return
+	}
+	defer r.Body.Close()
+
+	var appeal model.Appeal
+	found := db.DB.First(&appeal, "Id = ? AND Organisation = ?", appealId, organisationId).Error == nil
+
+	// Appellants can make the moves meant for them, such as withdrawing, without
+	// needing any permissions in the organisation
+	actorType := lifecycle.ActorModerator
+	if found && isAppellant(currentUser, appeal) && lifecycle.CanPerform(appeal.Status, transitionRequest.Status, lifecycle.ActorAppellant) {
+		actorType = lifecycle.ActorAppellant
+	} else if !request.RequirePermission(w, r, organisationId, rbac.PermissionAppealsRespond) {
+		return
+	} else if !found {
+		request.Respond(w, http.StatusNotFound, "Appeal not found")
+		return
+	}
+
+	if _, err := lifecycle.Transition(&appeal, transitionRequest.Status, actorType, &currentUser.UserID, transitionRequest.Reason); err != nil {
+		respondWithStatusError(w, err)
+	} else {
+		request.Respond(w, http.StatusOK, appeal)
+	}
+}
+
+func GetAppealHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+	appealId, _ := uuid.Parse(vars["appealId"])
+	currentUser := request.CurrentPrincipal(r)
+
+	var appeal model.Appeal
+	found := db.DB.First(&appeal, "Id = ? AND Organisation = ?", appealId, organisationId).Error == nil
+
+	allowed := []string{}
+	if found && isAppellant(currentUser, appeal) {
+		allowed = append(allowed, lifecycle.Allowed(appeal.Status, lifecycle.ActorAppellant)...)
+	} else if !request.RequirePermission(w, r, organisationId, rbac.PermissionAppealsRead) {
+		return
+	} else if !found {
+		request.Respond(w, http.StatusNotFound, "Appeal not found")
+		return
+	}
+	if currentUser.HasPermission(organisationId, rbac.PermissionAppealsRespond) {
+		allowed = append(allowed, lifecycle.Allowed(appeal.Status, lifecycle.ActorModerator)...)
+	}
+
+	if transitions, err := lifecycle.History(appeal.ID); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting appeal history. Error code '%s'", *sentryError))
+	} else {
+		request.Respond(w, http.StatusOK, appealmodel.HistoryResponse{Status: appeal.Status, Allowed: allowed, Transitions: transitions})
+	}
+}
+
+func AssignAppeal(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionAppealsRespond) {
 		appealId, _ := uuid.Parse(vars["appealId"])
 
-		var appealResponse model.AppealResponse
+		var organisation model.Organisation
+		var appeal model.Appeal
+		var assignRequest appealmodel.AssignRequest
 		decoder := json.NewDecoder(r.Body)
-		if err := decoder.Decode(&appealResponse); err != nil {
+		if err := decoder.Decode(&assignRequest); err != nil {
 			sentryError := sentry.CaptureException(err)
 			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+			request.Respond(w, http.StatusNotFound, "Organisation not found")
+		} else if err := db.DB.First(&appeal, "Id = ? AND Organisation = ?", appealId, organisationId); err.Error != nil {
+			request.Respond(w, http.StatusNotFound, "Appeal not found")
 		} else {
 			defer r.Body.Close()
 
-			currentUserId := authentication.GetCurrentUser(w, r)["Id"].(string)
+			if err := queue.Assign(&appeal, organisation, assignRequest.Moderator); err != nil {
+				respondWithQueueError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, appeal)
+			}
+		}
+	}
+}
 
-			appealResponse.Author, _ = uuid.Parse(currentUserId)
-			appealResponse.Appeal = appealId
+func TagAppeal(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
 
-			if err := db.DB.Create(&appealResponse); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating new Appeal Response. Error code '%s'", *sentryError))
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionAppealsRespond) {
+		appealId, _ := uuid.Parse(vars["appealId"])
+
+		var appeal model.Appeal
+		var tagsRequest appealmodel.TagsRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&tagsRequest); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else if err := db.DB.First(&appeal, "Id = ? AND Organisation = ?", appealId, organisationId); err.Error != nil {
+			request.Respond(w, http.StatusNotFound, "Appeal not found")
+		} else {
+			defer r.Body.Close()
+
+			if err := queue.SetTags(&appeal, tagsRequest.Tags); err != nil {
+				respondWithQueueError(w, err)
 			} else {
-				if appealResponse.Decision != 0 {
-					var appeal model.Appeal
-					if err := db.DB.First(&appeal, "Id = ?", appealId); err.Error != nil {
-						appeal.AppealStatus = appealResponse.Decision
-						if err := db.DB.Save(&appeal); err.Error != nil {
-							sentryError := sentry.CaptureException(err.Error)
-							request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating new Appeal Response. Error code '%s'", *sentryError))
-						} else {
-							request.Respond(w, http.StatusOK, "Appeal response created & decision sent")
-						}
-					}
-				}
-				request.Respond(w, http.StatusOK, appealResponse)
+				request.Respond(w, http.StatusOK, appeal)
 			}
 		}
 	}
 }
+
+func isAppellant(currentUser *principal.Principal, appeal model.Appeal) bool {
+	return currentUser.AuthMethod == principal.AuthMethodToken && appeal.Creator == currentUser.UserID
+}
+
+func respondWithStatusError(w http.ResponseWriter, err error) {
+	switch err {
+	case lifecycle.ErrInvalidStatus:
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid status - Appeals can be %v", lifecycle.Statuses))
+	case lifecycle.ErrInvalidTransition:
+		request.Respond(w, http.StatusConflict, "🚫 The appeal cannot be moved to that status from its current one")
+	case lifecycle.ErrNotAllowed:
+		request.Respond(w, http.StatusForbidden, "🚫 You cannot move the appeal to that status")
+	case lifecycle.ErrStatusChanged:
+		request.Respond(w, http.StatusConflict, "The appeal was updated by someone else - Refresh it and try again")
+	default:
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst updating the appeal. Error code '%s'", *sentryError))
+	}
+}
+
+// respondWithPublishingError explains why the template is not accepting appeals.
+func respondWithPublishingError(w http.ResponseWriter, err error, template model.AppealTemplate) {
+	switch err {
+	case publishing.ErrArchived:
+		request.Respond(w, http.StatusForbidden, "🚫 This form is no longer accepting appeals")
+	case publishing.ErrNotPublished:
+		request.Respond(w, http.StatusNotFound, "Template not found")
+	case publishing.ErrNotOpenYet:
+		request.Respond(w, http.StatusForbidden, fmt.Sprintf("🚫 This form opens for appeals at %s", template.OpensAt.UTC().Format(time.RFC3339)))
+	case publishing.ErrClosed:
+		request.Respond(w, http.StatusForbidden, "🚫 This form has closed for appeals")
+	default:
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Appeal creation failed. Error code '%s'", *sentryError))
+	}
+}
+
+func respondWithQueueError(w http.ResponseWriter, err error) {
+	switch err {
+	case queue.ErrInvalidCursor:
+		request.Respond(w, http.StatusBadRequest, "Invalid cursor - Start again from the first page, keeping the same sort and order")
+	case queue.ErrNotModerator:
+		request.Respond(w, http.StatusBadRequest, "🚫 Appeals can only be assigned to members who can respond to them")
+	case queue.ErrInvalidTag:
+		request.Respond(w, http.StatusBadRequest, "🚫 Tags must be 1 to 32 letters, numbers, spaces, dashes or underscores")
+	case queue.ErrTooManyTags:
+		request.Respond(w, http.StatusBadRequest, "🚫 Appeals can have at most 20 tags")
+	default:
+		if _, ok := err.(*queue.InvalidParameterError); ok {
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid filter - %s", err))
+			return
+		}
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting Appeals. Error code '%s'", *sentryError))
+	}
+}
@@ -3,26 +3,46 @@ package templates
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"time"
 
-	"github.com/benhall-1/appealscc/api/internal/authentication"
 	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/fields"
 	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/templatemodel"
+	"github.com/benhall-1/appealscc/api/internal/principal"
+	"github.com/benhall-1/appealscc/api/internal/publishing"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
 	"github.com/benhall-1/appealscc/api/internal/request"
-	"github.com/benhall-1/appealscc/api/internal/utils"
+	"github.com/benhall-1/appealscc/api/internal/templatedocs"
+	"github.com/benhall-1/appealscc/api/internal/versions"
 	"github.com/getsentry/sentry-go"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
+// freeTemplateLimit is how many templates an organisation can have on the Free
+// plan.
+const freeTemplateLimit = 2
+
+// galleryCacheAge is how long the starter templates, which only change when the
+// API is deployed, may be cached.
+const galleryCacheAge = time.Hour
+
 func GetAllTemplates(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["organisationId"])
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
 
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesRead) {
 		var templates []model.AppealTemplate
 
-		if err := db.DB.Find(&templates, "organisation = ?", organisationId); err.Error != nil {
+		query := db.DB.Where("organisation = ?", organisationId)
+		if status := r.URL.Query().Get("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		if err := query.Find(&templates); err.Error != nil {
 			sentryError := sentry.CaptureException(err.Error)
 			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst getting all Appeal Templates. Error code '%s'", *sentryError))
 		} else {
@@ -32,14 +52,15 @@ func GetAllTemplates(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetTemplateById(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["organisationId"])
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesRead) {
 		templateId, _ := uuid.Parse(vars["templateId"])
 
 		var template model.AppealTemplate
 
-		if err := db.DB.Preload("AppealTemplateFields").First(&template, "organisation = ? AND Id = ?", organisationId, templateId); err.Error != nil {
+		if err := versions.Preload(db.DB).First(&template, "organisation = ? AND Id = ?", organisationId, templateId); err.Error != nil {
 			sentryError := sentry.CaptureException(err.Error)
 			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst getting Appeal Template. Error code '%s'", *sentryError))
 		} else {
@@ -49,119 +70,446 @@ func GetTemplateById(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateTemplate(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["organisationId"])
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
 
-		currentUser := authentication.GetCurrentUser(w, r)
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
+		currentUser := request.CurrentPrincipal(r)
 
-		if utils.IsOrganisationOwnerOrGlobalAdmin(organisationId, currentUser) {
+		var tempOrg model.Organisation
+		currentUserPremiumType := currentUser.PremiumType
 
-			var tempOrg model.Organisation
-			currentUserPremiumType := authentication.GetCurrentUser(w, r)["PremiumType"].(float64)
-
-			if err := db.DB.Preload("AppealTemplates").First(&tempOrg, "Id = ?", organisationId); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating a new Appeal Template. Error code '%s'", *sentryError))
+		if err := db.DB.Preload("AppealTemplates", publishing.NotArchived).First(&tempOrg, "Id = ?", organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating a new Appeal Template. Error code '%s'", *sentryError))
+		} else {
+			if currentUserPremiumType == 0 && len(tempOrg.AppealTemplates) >= freeTemplateLimit {
+				request.Respond(w, http.StatusBadRequest, "Error whilst creating a new appeal template - You have reached the maximum number of appeal templates for the Free plan.")
 			} else {
-				if currentUserPremiumType == 0 && len(tempOrg.AppealTemplates) == 2 {
-					request.Respond(w, http.StatusBadRequest, "Error whilst creating a new appeal template - You have reached the maximum number of appeal templates for the Free plan.")
+				var appealTemplate model.AppealTemplate
+				decoder := json.NewDecoder(r.Body)
+				if err := decoder.Decode(&appealTemplate); err != nil {
+					sentryError := sentry.CaptureException(err)
+					request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
 				} else {
-					var appealTemplate model.AppealTemplate
-					decoder := json.NewDecoder(r.Body)
-					if err := decoder.Decode(&appealTemplate); err != nil {
-						sentryError := sentry.CaptureException(err)
-						request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
-					} else {
-						defer r.Body.Close()
+					defer r.Body.Close()
 
-						appealTemplate.Organisation = organisationId
+					// Templates start as drafts so they can be finished before appellants
+					// see them
+					appealTemplate.Organisation = organisationId
+					appealTemplate.Status = publishing.StatusDraft
+					appealTemplate.OpensAt = nil
+					appealTemplate.ClosesAt = nil
 
-						if err := db.DB.Create(&appealTemplate); err.Error != nil {
-							sentryError := sentry.CaptureException(err.Error)
-							request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating a new Appeal Template. Error code '%s'", *sentryError))
-						} else {
-							request.Respond(w, http.StatusOK, appealTemplate)
-						}
+					if errs := fields.ValidateTemplate(appealTemplate.Sections, appealTemplate.AppealTemplateFields); len(errs) > 0 {
+						request.Respond(w, http.StatusUnprocessableEntity, templatemodel.ValidationErrorResponse{Message: "😢 Some of the questions need changing before the template can be saved", Errors: errs})
+					} else if err := versions.CreateTemplate(&appealTemplate, &currentUser.UserID); err != nil {
+						sentryError := sentry.CaptureException(err)
+						request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating a new Appeal Template. Error code '%s'", *sentryError))
+					} else {
+						request.Respond(w, http.StatusOK, appealTemplate)
 					}
 				}
 			}
-		} else {
-			request.Respond(w, http.StatusForbidden, "Access Denied - You are not the owner of the organisation")
 		}
 	}
 }
 
 func UpdateTemplate(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["organisationId"])
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
 		templateId, _ := uuid.Parse(vars["templateId"])
 
-		currentUser := authentication.GetCurrentUser(w, r)
+		var appealTemplate model.AppealTemplate
+
+		if err := versions.Preload(db.DB).First(&appealTemplate, "organisation = ? AND Id = ?", organisationId, templateId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Appeal template does not exist. Error code '%s'", *sentryError))
+		} else {
+			var bodyTemplate model.AppealTemplate
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&bodyTemplate); err != nil {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+			} else {
+				defer r.Body.Close()
 
-		if utils.IsOrganisationOwnerOrGlobalAdmin(organisationId, currentUser) {
+				if bodyTemplate.Name == "" {
+					bodyTemplate.Name = appealTemplate.Name
+				}
+
+				// Edits are saved as a new version, so appeals already submitted keep
+				// the questions they answered
+				if errs := fields.ValidateTemplate(bodyTemplate.Sections, bodyTemplate.AppealTemplateFields); len(errs) > 0 {
+					request.Respond(w, http.StatusUnprocessableEntity, templatemodel.ValidationErrorResponse{Message: "😢 Some of the questions need changing before the template can be saved", Errors: errs})
+				} else if err := versions.Update(&appealTemplate, model.AppealTemplateVersion{Name: bodyTemplate.Name, Sections: bodyTemplate.Sections, Fields: bodyTemplate.AppealTemplateFields}, &request.CurrentPrincipal(r).UserID); err != nil {
+					sentryError := sentry.CaptureException(err)
+					request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst update the Appeal Template. Error code '%s'", *sentryError))
+				} else {
+					request.Respond(w, http.StatusOK, appealTemplate)
+				}
+			}
+		}
+	}
+}
 
-			var appealTemplate model.AppealTemplate
+func ReorderTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
 
-			if err := db.DB.First(&appealTemplate, "Id = ?", templateId); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Appeal template does not exist. Error code '%s'", *sentryError))
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			var reorderRequest templatemodel.ReorderRequest
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&reorderRequest); err != nil {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
 			} else {
+				defer r.Body.Close()
 
-				decoder := json.NewDecoder(r.Body)
-				if err := decoder.Decode(&appealTemplate); err != nil {
-					sentryError := sentry.CaptureException(err)
-					request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+				if err := versions.Reorder(template, reorderRequest, &request.CurrentPrincipal(r).UserID); err != nil {
+					respondWithVersionError(w, err)
 				} else {
-					defer r.Body.Close()
+					request.Respond(w, http.StatusOK, template)
+				}
+			}
+		}
+	}
+}
 
-					appealTemplate.Organisation = organisationId
+func GetTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesRead) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			if templateVersions, err := versions.List(template.ID); err != nil {
+				respondWithVersionError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, templateVersions)
+			}
+		}
+	}
+}
+
+func GetTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesRead) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			if version, err := versions.Get(template.ID, vars["version"]); err != nil {
+				respondWithVersionError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, version)
+			}
+		}
+	}
+}
+
+func GetTemplateVersionDiff(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesRead) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			if from, err := versions.Get(template.ID, vars["version"]); err != nil {
+				respondWithVersionError(w, err)
+			} else if to, err := versions.Get(template.ID, vars["otherVersion"]); err != nil {
+				respondWithVersionError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, versions.Diff(*from, *to))
+			}
+		}
+	}
+}
 
-					if err := db.DB.Model(&appealTemplate).Omit("AppealTemplateFields.*").Save(&appealTemplate); err.Error != nil {
-						sentryError := sentry.CaptureException(err.Error)
-						request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst update the Appeal Template. Error code '%s'", *sentryError))
+func RollbackTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			if version, err := versions.Get(template.ID, vars["version"]); err != nil {
+				respondWithVersionError(w, err)
+			} else if err := versions.Rollback(template, version.Number, &request.CurrentPrincipal(r).UserID); err != nil {
+				respondWithVersionError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, template)
+			}
+		}
+	}
+}
+
+func ExportTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesRead) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			if format, err := templatedocs.FormatOf(r.URL.Query().Get("format"), ""); err != nil {
+				respondWithDocumentError(w, err)
+			} else if document, err := templatedocs.Export(*template); err != nil {
+				respondWithDocumentError(w, err)
+			} else if encoded, err := templatedocs.Encode(document, format); err != nil {
+				respondWithDocumentError(w, err)
+			} else {
+				w.Header().Set("Content-Type", templatedocs.ContentType(format))
+				w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="template-%s.%s"`, template.ID, format))
+				w.WriteHeader(http.StatusOK)
+				w.Write(encoded)
+			}
+		}
+	}
+}
+
+func ImportTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
+		format, err := templatedocs.FormatOf(r.URL.Query().Get("format"), r.Header.Get("Content-Type"))
+		if err != nil {
+			respondWithDocumentError(w, err)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, templatedocs.MaxDocumentSize+1))
+		defer r.Body.Close()
+		if err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else if len(body) > templatedocs.MaxDocumentSize {
+			request.Respond(w, http.StatusRequestEntityTooLarge, "🚫 Template documents must be 1MB or smaller")
+		} else if document, err := templatedocs.Decode(body, format); err != nil {
+			respondWithDocumentError(w, err)
+		} else {
+			importDocument(w, r, organisationId, document, r.URL.Query().Get("conflict"))
+		}
+	}
+}
+
+func CloneTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesRead) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			var cloneRequest templatemodel.CloneTemplateRequest
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&cloneRequest); err != nil {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+			} else {
+				defer r.Body.Close()
+
+				if cloneRequest.Organisation == uuid.Nil {
+					cloneRequest.Organisation = organisationId
+				}
+
+				// Cloning goes through the same document as exporting, so a clone is
+				// exactly what importing an export would give
+				if request.RequirePermission(w, r, cloneRequest.Organisation, rbac.PermissionTemplatesWrite) {
+					if document, err := templatedocs.Export(*template); err != nil {
+						respondWithDocumentError(w, err)
 					} else {
-						if err := db.DB.Model(&appealTemplate).Association("AppealTemplateFields").Replace(&appealTemplate.AppealTemplateFields); err != nil {
-							sentryError := sentry.CaptureException(err)
-							request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst update the Appeal Template. Error code '%s'", *sentryError))
-						} else {
-							if err := db.DB.Unscoped().Delete(model.AppealTemplateField{}, "template IS NULL"); err.Error != nil {
-								sentryError := sentry.CaptureException(err.Error)
-								request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst update the Appeal Template. Error code '%s'", *sentryError))
-							} else {
-								request.Respond(w, http.StatusOK, appealTemplate)
-							}
-						}
+						importDocument(w, r, cloneRequest.Organisation, document, cloneRequest.Conflict)
 					}
 				}
 			}
+		}
+	}
+}
+
+func GetGallery(w http.ResponseWriter, r *http.Request) {
+	request.RespondCached(w, r, galleryCacheAge, templatedocs.Gallery)
+}
+
+func GetGalleryTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if document, err := templatedocs.Starter(vars["starterId"]); err != nil {
+		respondWithDocumentError(w, err)
+	} else {
+		request.RespondCached(w, r, galleryCacheAge, document)
+	}
+}
+
+func CreateTemplateFromGallery(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
+		if document, err := templatedocs.Starter(vars["starterId"]); err != nil {
+			respondWithDocumentError(w, err)
 		} else {
-			request.Respond(w, http.StatusForbidden, "Access Denied - You are not the owner of the organisation")
+			importDocument(w, r, organisationId, document, r.URL.Query().Get("conflict"))
 		}
 	}
 }
 
-func DeleteTemplate(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["organisationId"])
-		templateId, _ := uuid.Parse(vars["templateId"])
-		currentUser := authentication.GetCurrentUser(w, r)
+func SetTemplateStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			var statusRequest templatemodel.StatusRequest
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&statusRequest); err != nil {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+			} else {
+				defer r.Body.Close()
+
+				if err := publishing.SetStatus(template, statusRequest.Status, templateLimit(request.CurrentPrincipal(r))); err != nil {
+					respondWithPublishingError(w, err)
+				} else {
+					request.Respond(w, http.StatusOK, template)
+				}
+			}
+		}
+	}
+}
 
-		if utils.IsOrganisationOwnerOrGlobalAdmin(organisationId, currentUser) {
-			template := model.AppealTemplate{}
+func ScheduleTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
 
-			if err := db.DB.First(&template, "Id = ?", templateId); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Template not found. Error code '%s'", *sentryError))
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
+		if template, ok := templateInOrganisation(w, organisationId, vars["templateId"]); ok {
+			var scheduleRequest templatemodel.ScheduleRequest
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&scheduleRequest); err != nil {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
 			} else {
+				defer r.Body.Close()
 
-				db.DB.Unscoped().Delete(&template)
-				request.Respond(w, http.StatusOK, "Template deleted")
+				if err := publishing.Schedule(template, scheduleRequest.OpensAt, scheduleRequest.ClosesAt); err != nil {
+					respondWithPublishingError(w, err)
+				} else {
+					request.Respond(w, http.StatusOK, template)
+				}
 			}
+		}
+	}
+}
+
+func DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["organisationId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionTemplatesWrite) {
+		templateId, _ := uuid.Parse(vars["templateId"])
+
+		template := model.AppealTemplate{}
+
+		if err := db.DB.First(&template, "organisation = ? AND Id = ?", organisationId, templateId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Template not found. Error code '%s'", *sentryError))
+		} else if err := publishing.SetStatus(&template, publishing.StatusArchived, 0); err != nil {
+			respondWithPublishingError(w, err)
 		} else {
-			request.Respond(w, http.StatusForbidden, "Access Denied - You are not the owner of the organisation")
+			// Templates are archived rather than deleted so their appeals are kept
+			request.Respond(w, http.StatusOK, "Template archived")
+		}
+	}
+}
+
+func templateInOrganisation(w http.ResponseWriter, organisationId uuid.UUID, id string) (*model.AppealTemplate, bool) {
+	templateId, _ := uuid.Parse(id)
+
+	var template model.AppealTemplate
+	if err := versions.Preload(db.DB).First(&template, "organisation = ? AND Id = ?", organisationId, templateId); err.Error != nil {
+		request.Respond(w, http.StatusNotFound, "Appeal template not found")
+		return nil, false
+	}
+	return &template, true
+}
+
+func respondWithVersionError(w http.ResponseWriter, err error) {
+	switch err {
+	case versions.ErrVersionNotFound:
+		request.Respond(w, http.StatusNotFound, "Template version not found")
+	case versions.ErrInvalidOrder:
+		request.Respond(w, http.StatusBadRequest, "Invalid order - List every section and question of the template exactly once, putting each question in one of the sections")
+	case versions.ErrAlreadyCurrent:
+		request.Respond(w, http.StatusConflict, "This is already the current version of the template")
+	default:
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst getting template versions. Error code '%s'", *sentryError))
+	}
+}
+
+// importDocument checks the questions of the document and adds it to the
+// organisation, responding with the new template.
+func importDocument(w http.ResponseWriter, r *http.Request, organisationId uuid.UUID, document templatemodel.TemplateDocument, conflict string) {
+	draft := templatedocs.Draft(document)
+	if draft.Name == "" {
+		request.Respond(w, http.StatusUnprocessableEntity, templatemodel.ValidationErrorResponse{Message: "😢 The template needs a name before it can be imported", Errors: map[string]string{}})
+	} else if errs := fields.ValidateTemplate(draft.Sections, draft.Fields); len(errs) > 0 {
+		request.Respond(w, http.StatusUnprocessableEntity, templatemodel.ValidationErrorResponse{Message: "😢 Some of the questions need changing before the template can be imported", Errors: errs})
+	} else if template, err := templatedocs.Import(organisationId, draft, conflict, templateLimit(request.CurrentPrincipal(r)), &request.CurrentPrincipal(r).UserID); err != nil {
+		respondWithDocumentError(w, err)
+	} else {
+		request.Respond(w, http.StatusOK, template)
+	}
+}
+
+// templateLimit is how many templates the user can have in an organisation, or
+// 0 when there is no limit.
+func templateLimit(p *principal.Principal) int {
+	if p.PremiumType == 0 {
+		return freeTemplateLimit
+	}
+	return 0
+}
+
+func respondWithDocumentError(w http.ResponseWriter, err error) {
+	switch err {
+	case templatedocs.ErrUnknownFormat:
+		request.Respond(w, http.StatusBadRequest, "Unknown format - Templates can be exported and imported as json or yaml")
+	case templatedocs.ErrUnknownConflict:
+		request.Respond(w, http.StatusBadRequest, "Unknown conflict - Choose rename, replace or fail for when a template with the same name already exists")
+	case templatedocs.ErrNotTemplate:
+		request.Respond(w, http.StatusBadRequest, "🚫 This document is not an exported appeal template")
+	case templatedocs.ErrUnsupportedVersion:
+		request.Respond(w, http.StatusBadRequest, "🚫 This template was exported by a newer version and cannot be imported yet")
+	case templatedocs.ErrNameTaken:
+		request.Respond(w, http.StatusConflict, "🚫 This organisation already has a template with the same name")
+	case templatedocs.ErrTemplateLimit:
+		request.Respond(w, http.StatusBadRequest, "Error whilst importing the appeal template - You have reached the maximum number of appeal templates for the Free plan.")
+	case templatedocs.ErrStarterNotFound:
+		request.Respond(w, http.StatusNotFound, "Starter template not found")
+	case versions.ErrNoVersion:
+		request.Respond(w, http.StatusNotFound, "This template has no questions to export")
+	default:
+		if _, ok := err.(*templatedocs.InvalidDocumentError); ok {
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid document - %s", err))
+			return
 		}
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst importing the Appeal Template. Error code '%s'", *sentryError))
+	}
+}
+
+func respondWithPublishingError(w http.ResponseWriter, err error) {
+	switch err {
+	case publishing.ErrInvalidStatus:
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid status - Templates can be one of %v", publishing.Statuses))
+	case publishing.ErrAlreadyInStatus:
+		request.Respond(w, http.StatusConflict, "The template is already in this status")
+	case publishing.ErrNoQuestions:
+		request.Respond(w, http.StatusBadRequest, "🚫 Add at least one question before publishing the template")
+	case publishing.ErrInvalidWindow:
+		request.Respond(w, http.StatusBadRequest, "🚫 The template must open before it closes")
+	case publishing.ErrTemplateLimit:
+		request.Respond(w, http.StatusBadRequest, "Error whilst updating the appeal template - You have reached the maximum number of appeal templates for the Free plan.")
+	default:
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst updating the Appeal Template. Error code '%s'", *sentryError))
 	}
 }
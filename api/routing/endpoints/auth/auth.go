@@ -1,19 +1,20 @@
 package auth
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/benhall-1/appealscc/api/internal/authentication"
 	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/loginflow"
 	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
-	"github.com/benhall-1/appealscc/api/internal/models/discordmodel"
 	"github.com/benhall-1/appealscc/api/internal/models/model"
-	"github.com/benhall-1/appealscc/api/internal/oauth"
 	"github.com/benhall-1/appealscc/api/internal/request"
 	"github.com/getsentry/sentry-go"
 )
@@ -28,11 +29,16 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	} else {
 		defer r.Body.Close()
 
-		user.Email = requestUser.Email
+		user.Email = &requestUser.Email
 		user.Password = requestUser.Password
 
-		if status, _ := authentication.RegisterAccount(&user, nil); status {
-			request.Respond(w, http.StatusOK, "Account Registered")
+		if _, err := mail.ParseAddress(requestUser.Email); err != nil {
+			request.Respond(w, http.StatusBadRequest, "😢 Please enter a valid email address")
+		} else if status, registeredUser := authentication.RegisterAccount(&user); status {
+			if err := authentication.SendVerificationEmail(*registeredUser); err != nil {
+				sentry.CaptureException(err)
+			}
+			request.Respond(w, http.StatusOK, "Account Registered - Check your email to verify your address")
 		} else {
 			request.Respond(w, http.StatusBadRequest, "😢 Request failed - Please try again")
 		}
@@ -56,11 +62,15 @@ func Login(w http.ResponseWriter, r *http.Request) {
 			if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginRequest.Password)); err != nil {
 				sentryError := sentry.CaptureException(err)
 				request.Respond(w, http.StatusUnauthorized, fmt.Sprintf("🚫 Incorrect username or password. Error code: %s", *sentryError))
+			} else if authentication.EmailVerificationRequired() && !user.EmailVerified {
+				request.Respond(w, http.StatusForbidden, "🚫 Please verify your email address before logging in")
 			} else {
-				tokenResponse, err := authentication.GenerateToken(user)
+				tokenResponse, challenge, err := authentication.BeginLogin(user, r)
 				if err != nil {
 					sentryError := sentry.CaptureException(err)
 					request.Respond(w, http.StatusUnauthorized, fmt.Sprintf("🚫 Incorrect username or password. Error code: %s", *sentryError))
+				} else if challenge != nil {
+					request.Respond(w, http.StatusOK, challenge)
 				} else {
 					request.Respond(w, http.StatusOK, tokenResponse)
 				}
@@ -69,68 +79,166 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func RefreshToken(w http.ResponseWriter, r *http.Request) {
-	request.RefreshToken(w, r)
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var verifyRequest authmodel.EmailTokenRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&verifyRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		if _, err := authentication.VerifyEmail(verifyRequest.Token); err != nil {
+			if err == authentication.ErrInvalidVerificationToken {
+				request.Respond(w, http.StatusBadRequest, "😢 This verification link is invalid or has expired")
+			} else {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst verifying email. Error code '%s'", *sentryError))
+			}
+		} else {
+			request.Respond(w, http.StatusOK, "Email address verified")
+		}
+	}
 }
 
-var state = "random"
+func ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	var resendRequest authmodel.EmailRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&resendRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
 
-func LoginWithDiscord(w http.ResponseWriter, r *http.Request) {
-	http.Redirect(w, r, oauth.DiscordOAuth().AuthCodeURL(state), http.StatusTemporaryRedirect)
+		// Respond the same way whether or not the account exists or has been sent too many emails, so this can't be used to find registered emails
+		user := model.User{}
+		if result := db.DB.Find(&user, "Email = ?", resendRequest.Email); result.RowsAffected > 0 {
+			if err := authentication.SendVerificationEmail(user); err != nil && err != authentication.ErrAlreadyVerified && err != authentication.ErrVerificationThrottled {
+				sentry.CaptureException(err)
+			}
+		}
+		request.Respond(w, http.StatusOK, "If an unverified account exists for this email address, a verification email has been sent")
+	}
 }
 
-func AuthCallback(w http.ResponseWriter, r *http.Request) {
-	if r.FormValue("state") != state {
-		w.WriteHeader(http.StatusBadRequest)
-		request.Respond(w, http.StatusBadRequest, "State does not match.")
-		return
+func SetEmail(w http.ResponseWriter, r *http.Request) {
+	var emailRequest authmodel.EmailRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&emailRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		if _, err := mail.ParseAddress(emailRequest.Email); err != nil {
+			request.Respond(w, http.StatusBadRequest, "😢 Please enter a valid email address")
+		} else if err := authentication.SetEmail(request.CurrentPrincipal(r).UserID, emailRequest.Email); err != nil {
+			switch err {
+			case authentication.ErrAlreadyVerified:
+				request.Respond(w, http.StatusConflict, "🚫 Your account already has a verified email address")
+			case authentication.ErrEmailInUse:
+				request.Respond(w, http.StatusConflict, "🚫 Another account already uses this email address")
+			case authentication.ErrVerificationThrottled:
+				request.Respond(w, http.StatusTooManyRequests, "🚫 Email address saved, but too many verification emails have been requested - Please wait before resending")
+			default:
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst setting email address. Error code '%s'", *sentryError))
+			}
+		} else {
+			request.Respond(w, http.StatusOK, "Email address saved - Check your email to verify it")
+		}
 	}
-	// Step 3: We exchange the code we got for an access token
-	// Then we can use the access token to do actions, limited to scopes we requested
-	token, err := oauth.DiscordOAuth().Exchange(context.Background(), r.FormValue("code"))
+}
 
-	if err != nil {
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var refreshRequest authmodel.RefreshRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&refreshRequest); err != nil {
 		sentryError := sentry.CaptureException(err)
-		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst contacting discord. Error code '%s'", *sentryError))
-		return
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		tokenResponse, err := authentication.RotateRefreshToken(refreshRequest.RefreshToken, r)
+		if err != nil {
+			if err == authentication.ErrRefreshTokenReused {
+				request.Respond(w, http.StatusUnauthorized, "🚫 Refresh token has already been used - All sessions using it have been logged out")
+			} else if err == authentication.ErrInvalidRefreshToken {
+				request.Respond(w, http.StatusUnauthorized, "Expired token - Please try logging in again")
+			} else {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst refreshing token. Error code '%s'", *sentryError))
+			}
+		} else {
+			request.Respond(w, http.StatusOK, tokenResponse)
+		}
 	}
+}
 
-	// Step 4: Use the access token, here we use it to get the logged in user's info.
-	res, err := oauth.DiscordOAuth().Client(context.Background(), token).Get("https://discord.com/api/users/@me")
+func Logout(w http.ResponseWriter, r *http.Request) {
+	var refreshRequest authmodel.RefreshRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&refreshRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
 
-	if err != nil || res.StatusCode != 200 {
-		if err != nil {
-			sentryError := sentry.CaptureException(err)
-			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst fetching your details from discord. Error code '%s'", *sentryError))
+		if err := authentication.RevokeRefreshToken(refreshRequest.RefreshToken); err != nil {
+			if err == authentication.ErrInvalidRefreshToken {
+				request.Respond(w, http.StatusUnauthorized, "Expired token - Please try logging in again")
+			} else {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst logging out. Error code '%s'", *sentryError))
+			}
 		} else {
-			request.Respond(w, http.StatusInternalServerError, res.Status)
+			request.Respond(w, http.StatusOK, "Logged out")
 		}
-		return
 	}
+}
 
-	defer res.Body.Close()
+func LogoutAllSessions(w http.ResponseWriter, r *http.Request) {
+	currentUserId := request.CurrentPrincipal(r).UserID
 
-	var discordUser discordmodel.DiscordUser
-	decoder := json.NewDecoder(res.Body)
-	decoder.Decode(&discordUser)
+	if err := authentication.RevokeAllRefreshTokens(currentUserId); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst logging out. Error code '%s'", *sentryError))
+	} else {
+		request.Respond(w, http.StatusOK, "Logged out of all sessions")
+	}
+}
 
+// respondWithSession logs the user in at the end of a provider login, sending
+// them back to where they started when the attempt asked for a redirect.
+func respondWithSession(w http.ResponseWriter, r *http.Request, attempt *model.LoginAttempt, user model.User) {
+	token, challenge, err := authentication.BeginLogin(user, r)
 	if err != nil {
 		sentryError := sentry.CaptureException(err)
-		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst fetching your details from discord. Error code '%s'", *sentryError))
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst fetching your details. Error code '%s'", *sentryError))
 		return
 	}
 
-	if status, user := authentication.RegisterAccount(nil, &discordUser); status {
-		token, err := authentication.GenerateToken(*user)
-
-		if err != nil {
-			sentryError := sentry.CaptureException(err)
-			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst fetching your details. Error code '%s'", *sentryError))
-			return
+	if attempt.RedirectTo == "" {
+		if challenge != nil {
+			request.Respond(w, http.StatusOK, challenge)
 		} else {
 			request.Respond(w, http.StatusOK, token)
 		}
-	} else {
-		request.Respond(w, http.StatusBadRequest, "😢 Request failed - Please try again")
+		return
 	}
+
+	if challenge != nil {
+		loginflow.RedirectWithTokens(w, r, attempt, url.Values{
+			"challengeToken": {challenge.ChallengeToken},
+			"expiration":     {challenge.Expiration.Format(time.RFC3339)},
+		})
+		return
+	}
+
+	loginflow.RedirectWithTokens(w, r, attempt, url.Values{
+		"token":             {token.Token},
+		"expiration":        {token.Expiration.Format(time.RFC3339)},
+		"refreshToken":      {token.RefreshToken},
+		"refreshExpiration": {token.RefreshExpiration.Format(time.RFC3339)},
+	})
 }
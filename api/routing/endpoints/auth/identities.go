@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/authentication"
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/loginflow"
+	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/oauth"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func GetIdentities(w http.ResponseWriter, r *http.Request) {
+	currentUserId := request.CurrentPrincipal(r).UserID
+
+	identities := []model.ExternalIdentity{}
+	if err := db.DB.Find(&identities, "user = ?", currentUserId); err.Error != nil {
+		sentryError := sentry.CaptureException(err.Error)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting linked accounts. Error code '%s'", *sentryError))
+	} else {
+		request.Respond(w, http.StatusOK, identities)
+	}
+}
+
+func LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	currentUserId := request.CurrentPrincipal(r).UserID
+
+	provider, ok := oauth.GetProvider(vars["provider"])
+	if !ok {
+		request.Respond(w, http.StatusNotFound, fmt.Sprintf("😢 The provider '%s' is not supported", vars["provider"]))
+		return
+	}
+
+	authURL, err := loginflow.BeginLink(w, r, provider.Name(), provider.Config(), currentUserId)
+	if err != nil {
+		if err == loginflow.ErrInvalidRedirect {
+			request.Respond(w, http.StatusBadRequest, "🚫 The redirect_to address is not allowed")
+		} else {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst starting login. Error code '%s'", *sentryError))
+		}
+		return
+	}
+	request.Respond(w, http.StatusOK, authmodel.AuthorizeURLResponse{Url: authURL})
+}
+
+func UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	identityId, _ := uuid.Parse(vars["identityId"])
+	currentUserId := request.CurrentPrincipal(r).UserID
+
+	if err := authentication.UnlinkIdentity(currentUserId, identityId); err != nil {
+		if err == authentication.ErrIdentityNotFound {
+			request.Respond(w, http.StatusNotFound, "Linked account not found")
+		} else if err == authentication.ErrLastLoginMethod {
+			request.Respond(w, http.StatusBadRequest, "🚫 This is the only way to log in to your account - Link another account first")
+		} else {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst unlinking account. Error code '%s'", *sentryError))
+		}
+	} else {
+		request.Respond(w, http.StatusOK, "Account unlinked")
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/authentication"
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+)
+
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var forgotRequest authmodel.EmailRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&forgotRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		if err := authentication.RequestPasswordReset(forgotRequest.Email); err != nil {
+			sentry.CaptureException(err)
+		}
+		// Respond the same way whether or not the account exists, so this can't be used to find registered emails
+		request.Respond(w, http.StatusOK, "If an account exists for this email address, a password reset link has been sent")
+	}
+}
+
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var resetRequest authmodel.ResetPasswordRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&resetRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		if err := authentication.ResetPassword(resetRequest.Token, resetRequest.Password); err != nil {
+			if err == authentication.ErrInvalidResetToken {
+				request.Respond(w, http.StatusBadRequest, "😢 This password reset link is invalid or has expired")
+			} else if err == authentication.ErrWeakPassword {
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Your new %s", err))
+			} else {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst resetting password. Error code '%s'", *sentryError))
+			}
+		} else {
+			request.Respond(w, http.StatusOK, "Password reset - Please log in with your new password")
+		}
+	}
+}
+
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var changeRequest authmodel.ChangePasswordRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&changeRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		currentUserId := request.CurrentPrincipal(r).UserID
+
+		if err := authentication.ChangePassword(currentUserId, changeRequest.CurrentPassword, changeRequest.NewPassword); err != nil {
+			if err == authentication.ErrIncorrectPassword {
+				request.Respond(w, http.StatusUnauthorized, "🚫 Your current password is incorrect")
+			} else if err == authentication.ErrWeakPassword {
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Your new %s", err))
+			} else {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst changing password. Error code '%s'", *sentryError))
+			}
+			return
+		}
+
+		// Every session was logged out by the change, so start a new one for this device
+		user := model.User{}
+		if err := db.DB.First(&user, "Id = ?", currentUserId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("User not found. Error code '%s'", *sentryError))
+		} else if tokenResponse, err := authentication.CreateSession(user, r); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst logging in. Error code '%s'", *sentryError))
+		} else {
+			request.Respond(w, http.StatusOK, tokenResponse)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/authentication"
+	"github.com/benhall-1/appealscc/api/internal/loginflow"
+	"github.com/benhall-1/appealscc/api/internal/minecraft"
+	"github.com/benhall-1/appealscc/api/internal/oauth"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+)
+
+func LoginWithDiscord(w http.ResponseWriter, r *http.Request) {
+	beginProviderLogin(w, r, "discord")
+}
+
+func AuthCallback(w http.ResponseWriter, r *http.Request) {
+	completeProviderLogin(w, r, "discord")
+}
+
+func LoginWithTwitch(w http.ResponseWriter, r *http.Request) {
+	beginProviderLogin(w, r, "twitch")
+}
+
+func TwitchCallback(w http.ResponseWriter, r *http.Request) {
+	completeProviderLogin(w, r, "twitch")
+}
+
+func LoginWithMicrosoft(w http.ResponseWriter, r *http.Request) {
+	beginProviderLogin(w, r, "minecraft")
+}
+
+func MicrosoftCallback(w http.ResponseWriter, r *http.Request) {
+	completeProviderLogin(w, r, "minecraft")
+}
+
+func beginProviderLogin(w http.ResponseWriter, r *http.Request, providerName string) {
+	provider, _ := oauth.GetProvider(providerName)
+
+	authURL, err := loginflow.Begin(w, r, provider.Name(), provider.Config())
+	if err != nil {
+		if err == loginflow.ErrInvalidRedirect {
+			request.Respond(w, http.StatusBadRequest, "🚫 The redirect_to address is not allowed")
+		} else {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst starting login. Error code '%s'", *sentryError))
+		}
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// completeProviderLogin handles the callback for both logging in with a provider
+// and linking one to an existing account, depending on how the attempt started.
+func completeProviderLogin(w http.ResponseWriter, r *http.Request, providerName string) {
+	provider, _ := oauth.GetProvider(providerName)
+
+	attempt, err := loginflow.Complete(w, r, provider.Name())
+	if err != nil {
+		request.Respond(w, http.StatusBadRequest, "State does not match or has expired - Please try logging in again")
+		return
+	}
+
+	// Exchange the code we got for an access token, then use it to find out who logged in
+	token, err := provider.Config().Exchange(context.Background(), r.FormValue("code"), loginflow.ExchangeOptions(attempt)...)
+	if err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst contacting %s. Error code '%s'", provider.Name(), *sentryError))
+		return
+	}
+
+	identity, err := provider.GetIdentity(context.Background(), token)
+	if err != nil {
+		if err == minecraft.ErrNoXboxAccount || err == minecraft.ErrChildAccount || err == minecraft.ErrNoMinecraft {
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Could not verify your Minecraft account - %s", err))
+		} else {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst fetching your details from %s. Error code '%s'", provider.Name(), *sentryError))
+		}
+		return
+	}
+
+	if attempt.User != nil {
+		externalIdentity, err := authentication.LinkIdentity(*attempt.User, provider.Name(), identity, token)
+		if err != nil {
+			if err == authentication.ErrIdentityLinked {
+				request.Respond(w, http.StatusConflict, fmt.Sprintf("🚫 This %s account is already linked to another account", provider.Name()))
+			} else {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst linking your %s account. Error code '%s'", provider.Name(), *sentryError))
+			}
+		} else if attempt.RedirectTo != "" {
+			http.Redirect(w, r, attempt.RedirectTo, http.StatusFound)
+		} else {
+			request.Respond(w, http.StatusOK, externalIdentity)
+		}
+		return
+	}
+
+	user, err := authentication.ResolveIdentity(provider.Name(), identity, token)
+	if err != nil {
+		if err == authentication.ErrEmailInUse {
+			request.Respond(w, http.StatusConflict, fmt.Sprintf("🚫 An account with this email address already exists - Log in and link your %s account instead", provider.Name()))
+		} else {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst fetching your details. Error code '%s'", *sentryError))
+		}
+		return
+	}
+
+	respondWithSession(w, r, attempt, *user)
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/authentication"
+	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+)
+
+func LoginWithTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var loginRequest authmodel.TwoFactorLoginRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&loginRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		tokenResponse, err := authentication.CompleteTwoFactorLogin(loginRequest.ChallengeToken, loginRequest.Code, r)
+		if err != nil {
+			respondWithTwoFactorError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, tokenResponse)
+		}
+	}
+}
+
+func EnrolTwoFactor(w http.ResponseWriter, r *http.Request) {
+	currentUserId := request.CurrentPrincipal(r).UserID
+
+	enrolment, err := authentication.BeginTwoFactorEnrolment(currentUserId)
+	if err != nil {
+		respondWithTwoFactorError(w, err)
+	} else {
+		request.Respond(w, http.StatusOK, enrolment)
+	}
+}
+
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var codeRequest authmodel.TwoFactorCodeRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&codeRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		currentUserId := request.CurrentPrincipal(r).UserID
+
+		recoveryCodes, err := authentication.ConfirmTwoFactorEnrolment(currentUserId, codeRequest.Code)
+		if err != nil {
+			respondWithTwoFactorError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, authmodel.RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+		}
+	}
+}
+
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var codeRequest authmodel.TwoFactorCodeRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&codeRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		currentUserId := request.CurrentPrincipal(r).UserID
+
+		if err := authentication.DisableTwoFactor(currentUserId, codeRequest.Code); err != nil {
+			respondWithTwoFactorError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, "Two-factor authentication disabled")
+		}
+	}
+}
+
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var codeRequest authmodel.TwoFactorCodeRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&codeRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - Please try again. Error code: '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		currentUserId := request.CurrentPrincipal(r).UserID
+
+		recoveryCodes, err := authentication.RegenerateRecoveryCodes(currentUserId, codeRequest.Code)
+		if err != nil {
+			respondWithTwoFactorError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, authmodel.RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+		}
+	}
+}
+
+func respondWithTwoFactorError(w http.ResponseWriter, err error) {
+	switch err {
+	case authentication.ErrInvalidTwoFactor:
+		request.Respond(w, http.StatusUnauthorized, "🚫 Incorrect two-factor code")
+	case authentication.ErrInvalidChallenge:
+		request.Respond(w, http.StatusUnauthorized, "Expired login - Please try logging in again")
+	case authentication.ErrTwoFactorEnabled, authentication.ErrTwoFactorDisabled, authentication.ErrTwoFactorNotEnrolled, authentication.ErrTwoFactorRequired:
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("😢 Request failed - %s", err))
+	default:
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst processing two-factor authentication. Error code '%s'", *sentryError))
+	}
+}
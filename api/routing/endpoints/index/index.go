@@ -8,9 +8,7 @@ import (
 )
 
 func HomePage(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		request.Respond(w, http.StatusOK, "🎉 Success! Welcome to the AppealsCC API")
-	}
+	request.Respond(w, http.StatusOK, "🎉 Success! Welcome to the AppealsCC API")
 }
 
 func NotFound(w http.ResponseWriter, r *http.Request) {
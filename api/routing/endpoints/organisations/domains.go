@@ -0,0 +1,115 @@
+package organisations
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/domains"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/organisationmodel"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func GetDomains(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationRead) {
+		if customDomains, err := domains.ForOrganisation(organisationId); err != nil {
+			respondWithDomainError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, customDomains)
+		}
+	}
+}
+
+func AddDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationUpdate) {
+		var domainRequest organisationmodel.AddDomainRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&domainRequest); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else {
+			defer r.Body.Close()
+
+			if customDomain, err := domains.Add(organisationId, domainRequest.Domain); err != nil {
+				respondWithDomainError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, domainChallenge(*customDomain))
+			}
+		}
+	}
+}
+
+func VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	domainId, _ := uuid.Parse(vars["domainId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationUpdate) {
+		var verifyRequest organisationmodel.VerifyDomainRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&verifyRequest); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else {
+			defer r.Body.Close()
+
+			if customDomain, err := domains.Verify(organisationId, domainId, verifyRequest.Method); err == domains.ErrChallengeMissing {
+				request.Respond(w, http.StatusBadRequest, domainChallenge(*customDomain))
+			} else if err != nil {
+				respondWithDomainError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, customDomain)
+			}
+		}
+	}
+}
+
+func RemoveDomain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	domainId, _ := uuid.Parse(vars["domainId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationUpdate) {
+		if err := domains.Remove(organisationId, domainId); err != nil {
+			respondWithDomainError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, "Domain removed")
+		}
+	}
+}
+
+func domainChallenge(customDomain model.CustomDomain) organisationmodel.DomainChallengeResponse {
+	return organisationmodel.DomainChallengeResponse{
+		Domain:    customDomain,
+		TXTRecord: domains.ChallengeRecord(customDomain.Domain),
+		HTTPUrl:   fmt.Sprintf("http://%s%s", customDomain.Domain, domains.ChallengePath),
+		Value:     domains.ChallengeValue(customDomain.VerificationToken),
+	}
+}
+
+func respondWithDomainError(w http.ResponseWriter, err error) {
+	switch err {
+	case domains.ErrInvalidDomain:
+		request.Respond(w, http.StatusBadRequest, "Invalid domain - Enter a domain name such as appeals.example.com")
+	case domains.ErrDomainTaken:
+		request.Respond(w, http.StatusConflict, "This domain is already in use")
+	case domains.ErrDomainNotFound:
+		request.Respond(w, http.StatusNotFound, "Domain not found")
+	case domains.ErrInvalidMethod:
+		request.Respond(w, http.StatusBadRequest, "Invalid verification method - Use either 'dns' or 'http'")
+	default:
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst updating domains. Error code '%s'", *sentryError))
+	}
+}
@@ -0,0 +1,179 @@
+package organisations
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/invites"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/organisationmodel"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func CreateInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersManage) {
+		var inviteRequest organisationmodel.CreateInviteRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&inviteRequest); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else {
+			defer r.Body.Close()
+
+			organisation := model.Organisation{}
+			if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+				sentryError := sentry.CaptureException(err.Error)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+				return
+			}
+
+			link, invite, err := invites.Create(organisation, request.CurrentPrincipal(r), inviteRequest.Email, inviteRequest.DiscordUsername, inviteRequest.Role)
+			if err != nil && invite == nil {
+				respondWithInviteError(w, err)
+			} else {
+				// The invite still works if the email could not be sent, as the link can be shared directly
+				if err != nil {
+					sentry.CaptureException(err)
+				}
+				request.Respond(w, http.StatusOK, organisationmodel.CreateInviteResponse{Link: link, Invite: *invite})
+			}
+		}
+	}
+}
+
+func GetPendingInvites(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersManage) {
+		if pending, err := invites.Pending(organisationId); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting invites. Error code '%s'", *sentryError))
+		} else {
+			request.Respond(w, http.StatusOK, pending)
+		}
+	}
+}
+
+func RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	inviteId, _ := uuid.Parse(vars["inviteId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersManage) {
+		if err := invites.Revoke(organisationId, inviteId); err != nil {
+			respondWithInviteError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, "Invite revoked")
+		}
+	}
+}
+
+func GetMyInvites(w http.ResponseWriter, r *http.Request) {
+	currentUserId := request.CurrentPrincipal(r).UserID
+
+	if received, err := invites.ForUser(currentUserId); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting invites. Error code '%s'", *sentryError))
+	} else {
+		request.Respond(w, http.StatusOK, received)
+	}
+}
+
+func AcceptInviteLink(w http.ResponseWriter, r *http.Request) {
+	var tokenRequest organisationmodel.InviteTokenRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&tokenRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		if invite, err := invites.FindByToken(tokenRequest.Token); err != nil {
+			respondWithInviteError(w, err)
+		} else {
+			acceptInvite(w, r, invite)
+		}
+	}
+}
+
+func DeclineInviteLink(w http.ResponseWriter, r *http.Request) {
+	var tokenRequest organisationmodel.InviteTokenRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&tokenRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		if invite, err := invites.FindByToken(tokenRequest.Token); err != nil {
+			respondWithInviteError(w, err)
+		} else {
+			declineInvite(w, r, invite)
+		}
+	}
+}
+
+func AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	inviteId, _ := uuid.Parse(vars["inviteId"])
+
+	if invite, err := invites.FindById(inviteId); err != nil {
+		respondWithInviteError(w, err)
+	} else {
+		acceptInvite(w, r, invite)
+	}
+}
+
+func DeclineInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	inviteId, _ := uuid.Parse(vars["inviteId"])
+
+	if invite, err := invites.FindById(inviteId); err != nil {
+		respondWithInviteError(w, err)
+	} else {
+		declineInvite(w, r, invite)
+	}
+}
+
+func acceptInvite(w http.ResponseWriter, r *http.Request, invite *model.OrganisationInvite) {
+	if member, err := invites.Accept(invite, request.CurrentPrincipal(r).UserID); err != nil {
+		respondWithInviteError(w, err)
+	} else {
+		request.Respond(w, http.StatusOK, member)
+	}
+}
+
+func declineInvite(w http.ResponseWriter, r *http.Request, invite *model.OrganisationInvite) {
+	if err := invites.Decline(invite, request.CurrentPrincipal(r).UserID); err != nil {
+		respondWithInviteError(w, err)
+	} else {
+		request.Respond(w, http.StatusOK, "Invite declined")
+	}
+}
+
+func respondWithInviteError(w http.ResponseWriter, err error) {
+	switch err {
+	case invites.ErrInvalidRecipient:
+		request.Respond(w, http.StatusBadRequest, "Invalid invite - Enter either a valid email address or a Discord username")
+	case invites.ErrInviteNotFound:
+		request.Respond(w, http.StatusNotFound, "😢 This invite is invalid, has expired or has already been used")
+	case invites.ErrWrongRecipient:
+		request.Respond(w, http.StatusForbidden, "🚫 This invite was sent to someone else - Log in with the verified email address or linked Discord account it was sent to")
+	case invites.ErrAlreadyInvited:
+		request.Respond(w, http.StatusConflict, "This person already has a pending invite")
+	case rbac.ErrAlreadyMember:
+		request.Respond(w, http.StatusConflict, "You are already a member of this organisation")
+	default:
+		respondWithRoleError(w, err)
+	}
+}
@@ -0,0 +1,174 @@
+package organisations
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/organisationmodel"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func GetMembers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersRead) {
+		if members, err := rbac.Members(organisationId); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting members. Error code '%s'", *sentryError))
+		} else {
+			request.Respond(w, http.StatusOK, members)
+		}
+	}
+}
+
+func AssignMemberRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	userId, _ := uuid.Parse(vars["userId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersManage) {
+		var assignmentRequest organisationmodel.RoleAssignmentRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&assignmentRequest); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else {
+			defer r.Body.Close()
+
+			organisation := model.Organisation{}
+			if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+				sentryError := sentry.CaptureException(err.Error)
+				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+			} else if member, err := rbac.AssignRole(organisation, userId, assignmentRequest.Role, request.CurrentPrincipal(r)); err != nil {
+				respondWithRoleError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, member)
+			}
+		}
+	}
+}
+
+func RemoveMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	userId, _ := uuid.Parse(vars["userId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersManage) {
+		organisation := model.Organisation{}
+		if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+		} else if err := rbac.RemoveMember(organisation, userId, request.CurrentPrincipal(r)); err != nil {
+			respondWithRoleError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, fmt.Sprintf("User Id '%s' removed from organisation '%s'", userId, organisation.Name))
+		}
+	}
+}
+
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersRead) {
+		if roles, err := rbac.CustomRoles(organisationId); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting roles. Error code '%s'", *sentryError))
+		} else {
+			request.Respond(w, http.StatusOK, organisationmodel.RolesResponse{BuiltIn: rbac.BuiltInRoles, Custom: roles, Permissions: rbac.Permissions})
+		}
+	}
+}
+
+func CreateRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionRolesManage) {
+		var roleRequest organisationmodel.RoleRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&roleRequest); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else {
+			defer r.Body.Close()
+
+			if role, err := rbac.CreateRole(organisationId, roleRequest.Name, roleRequest.Permissions, request.CurrentPrincipal(r)); err != nil {
+				respondWithRoleError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, role)
+			}
+		}
+	}
+}
+
+func UpdateRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	roleId, _ := uuid.Parse(vars["roleId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionRolesManage) {
+		var roleRequest organisationmodel.RoleRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&roleRequest); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else {
+			defer r.Body.Close()
+
+			if role, err := rbac.UpdateRole(organisationId, roleId, roleRequest.Name, roleRequest.Permissions, request.CurrentPrincipal(r)); err != nil {
+				respondWithRoleError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, role)
+			}
+		}
+	}
+}
+
+func DeleteRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	roleId, _ := uuid.Parse(vars["roleId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionRolesManage) {
+		if err := rbac.DeleteRole(organisationId, roleId, request.CurrentPrincipal(r)); err != nil {
+			respondWithRoleError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, "Role deleted")
+		}
+	}
+}
+
+func respondWithRoleError(w http.ResponseWriter, err error) {
+	switch err {
+	case rbac.ErrRoleNotFound:
+		request.Respond(w, http.StatusNotFound, "Role not found")
+	case rbac.ErrMemberNotFound:
+		request.Respond(w, http.StatusNotFound, "Member not found")
+	case rbac.ErrUserNotFound:
+		request.Respond(w, http.StatusNotFound, "User not found")
+	case rbac.ErrInvalidPermission:
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid permissions - Roles can be granted %v", rbac.Permissions))
+	case rbac.ErrInvalidRoleName:
+		request.Respond(w, http.StatusBadRequest, "Invalid role name - It must be between 1 and 64 characters and not the name of a built in role")
+	case rbac.ErrRoleInUse:
+		request.Respond(w, http.StatusConflict, "Cannot delete role - It is still assigned to members")
+	case rbac.ErrCannotGrant:
+		request.Respond(w, http.StatusForbidden, "Access Denied - You cannot grant or remove permissions you do not have")
+	case rbac.ErrOwnerRole:
+		request.Respond(w, http.StatusBadRequest, "The owner's role cannot be changed")
+	case rbac.ErrTwoFactorRequired:
+		request.Respond(w, http.StatusBadRequest, "Could not add user - This organisation requires members to enable two-factor authentication")
+	default:
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst updating roles. Error code '%s'", *sentryError))
+	}
+}
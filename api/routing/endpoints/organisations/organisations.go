@@ -5,29 +5,38 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/benhall-1/appealscc/api/internal/authentication"
 	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/authmodel"
 	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
 	"github.com/benhall-1/appealscc/api/internal/request"
-	"github.com/benhall-1/appealscc/api/internal/utils"
 	"github.com/getsentry/sentry-go"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 func GetAllOrganisations(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		organisations := []model.Organisation{}
-		db.DB.Preload("Moderators").Find(&organisations)
-		request.Respond(w, http.StatusOK, &organisations)
+	currentUser := request.CurrentPrincipal(r)
+	organisations := []model.Organisation{}
+
+	// Global admins see every organisation, everyone else only those they have a role in
+	query := db.DB.Preload("Members")
+	if !currentUser.GlobalAdmin {
+		organisationIds := []uuid.UUID{}
+		for organisationId := range currentUser.Memberships {
+			organisationIds = append(organisationIds, organisationId)
+		}
+		query = query.Where("id IN ?", organisationIds)
 	}
+	query.Find(&organisations)
+	request.Respond(w, http.StatusOK, &organisations)
 }
 
 func GetSingleOrganisation(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["id"])
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
 
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationRead) {
 		organisation := model.Organisation{}
 
 		if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
@@ -40,51 +49,53 @@ func GetSingleOrganisation(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetAllOrganisationsForUser(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		userId, _ := uuid.Parse(vars["userId"])
+	vars := mux.Vars(r)
+	userId, _ := uuid.Parse(vars["userId"])
+	currentUser := request.CurrentPrincipal(r)
 
-		organisation := []model.Organisation{}
+	if userId != currentUser.UserID && !currentUser.GlobalAdmin {
+		request.Respond(w, http.StatusForbidden, "Access Denied - You can only list your own organisations")
+		return
+	}
 
-		if err := db.DB.Find(&organisation, "owner_id = ?", userId); err.Error != nil {
-			sentryError := sentry.CaptureException(err.Error)
-			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("No organisations found for User ID '%s'. Error code '%s'", userId, *sentryError))
-		} else {
-			request.Respond(w, http.StatusOK, organisation)
-		}
+	organisation := []model.Organisation{}
+
+	if err := db.DB.Find(&organisation, "owner_id = ?", userId); err.Error != nil {
+		sentryError := sentry.CaptureException(err.Error)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("No organisations found for User ID '%s'. Error code '%s'", userId, *sentryError))
+	} else {
+		request.Respond(w, http.StatusOK, organisation)
 	}
 }
 
 func CreateOrganisation(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		var organisation model.Organisation
-		decoder := json.NewDecoder(r.Body)
-		if err := decoder.Decode(&organisation); err != nil {
-			sentryError := sentry.CaptureException(err)
-			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+	var organisation model.Organisation
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&organisation); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		currentUser := request.CurrentPrincipal(r)
+		currentUserId := currentUser.UserID
+		currentUserPremiumType := currentUser.PremiumType
+
+		var tempOrg model.Organisation
+
+		if err := db.DB.Find(&tempOrg, "owner_id = ?", currentUserId); err.Error != nil {
+			sentry.CaptureException(err.Error)
 		} else {
-			defer r.Body.Close()
-
-			currentUser := authentication.GetCurrentUser(w, r)
-			currentUserId := currentUser["Id"].(string)
-			currentUserPremiumType := currentUser["PremiumType"].(float64)
-
-			var tempOrg model.Organisation
-
-			if err := db.DB.Find(&tempOrg, "owner_id = ?", currentUserId); err.Error != nil {
-				sentry.CaptureException(err.Error)
+			if err.RowsAffected == 1 && currentUserPremiumType == 0 {
+				request.Respond(w, http.StatusBadRequest, "Cannot create organisation - You have reached your maximum limit of organisations for your account")
 			} else {
-				if err.RowsAffected == 1 && currentUserPremiumType == 0 {
-					request.Respond(w, http.StatusBadRequest, "Cannot create organisation - You have reached your maximum limit of organisations for your account")
+				organisation.OwnerID = currentUserId
+
+				if err := db.DB.Create(&organisation); err.Error != nil {
+					sentryError := sentry.CaptureException(err.Error)
+					request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating new Organisation. Error code '%s'", *sentryError))
 				} else {
-					organisation.OwnerID, _ = uuid.Parse(currentUserId)
-
-					if err := db.DB.Create(&organisation); err.Error != nil {
-						sentryError := sentry.CaptureException(err.Error)
-						request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst creating new Organisation. Error code '%s'", *sentryError))
-					} else {
-						request.Respond(w, http.StatusOK, organisation)
-					}
+					request.Respond(w, http.StatusOK, organisation)
 				}
 			}
 		}
@@ -92,131 +103,129 @@ func CreateOrganisation(w http.ResponseWriter, r *http.Request) {
 }
 
 func UpdateOrganisation(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["id"])
-		currentUser := authentication.GetCurrentUser(w, r)
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
 
-		if utils.IsOrganisationOwnerOrGlobalAdmin(organisationId, currentUser) {
-			organisation := model.Organisation{}
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationUpdate) {
+		organisation := model.Organisation{}
 
-			if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+		if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+		} else {
+			var bodyOrganisation model.Organisation
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&bodyOrganisation); err != nil {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Error whilst getting organisation. Error code '%s'", *sentryError))
 			} else {
-				var bodyOrganisation model.Organisation
-				decoder := json.NewDecoder(r.Body)
-				if err := decoder.Decode(&bodyOrganisation); err != nil {
-					sentryError := sentry.CaptureException(err)
-					request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Error whilst getting organisation. Error code '%s'", *sentryError))
-				} else {
-					defer r.Body.Close()
-
-					if bodyOrganisation.Name != "" {
-						organisation.Name = bodyOrganisation.Name
-					}
-					if bodyOrganisation.IconHash != nil {
-						organisation.IconHash = bodyOrganisation.IconHash
-					}
-					if bodyOrganisation.Description != "" {
-						organisation.Description = bodyOrganisation.Description
-					}
-					db.DB.Save(&organisation)
-					request.Respond(w, http.StatusOK, organisation)
+				defer r.Body.Close()
+
+				if bodyOrganisation.Name != "" {
+					organisation.Name = bodyOrganisation.Name
 				}
+				if bodyOrganisation.IconHash != nil {
+					organisation.IconHash = bodyOrganisation.IconHash
+				}
+				if bodyOrganisation.Description != "" {
+					organisation.Description = bodyOrganisation.Description
+				}
+				db.DB.Save(&organisation)
+				request.Respond(w, http.StatusOK, organisation)
 			}
-		} else {
-			request.Respond(w, http.StatusForbidden, "Access Denied - You are not the owner of the organisation")
 		}
 	}
 }
 
 func DeleteOrganisation(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["id"])
-		currentUser := authentication.GetCurrentUser(w, r)
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationDelete) {
+		organisation := model.Organisation{}
+
+		if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+		} else {
+
+			db.DB.Unscoped().Delete(&organisation)
+			request.Respond(w, http.StatusOK, "Organisation deleted")
+		}
+	}
+}
 
-		if utils.IsOrganisationOwnerOrGlobalAdmin(organisationId, currentUser) {
-			organisation := model.Organisation{}
+func UpdateTwoFactorRequirement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	currentUser := request.CurrentPrincipal(r)
 
-			if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationSecurity) {
+		organisation := model.Organisation{}
+
+		if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+		} else {
+			var requirementRequest authmodel.TwoFactorRequirementRequest
+			decoder := json.NewDecoder(r.Body)
+			if err := decoder.Decode(&requirementRequest); err != nil {
+				sentryError := sentry.CaptureException(err)
+				request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
 			} else {
+				defer r.Body.Close()
 
-				db.DB.Unscoped().Delete(&organisation)
-				request.Respond(w, http.StatusOK, "Organisation deleted")
+				if requirementRequest.RequireTwoFactor && !currentUser.TwoFactor {
+					request.Respond(w, http.StatusBadRequest, "Cannot require two-factor authentication - Enable it on your own account first")
+					return
+				}
+
+				organisation.RequireTwoFactor = requirementRequest.RequireTwoFactor
+				db.DB.Save(&organisation)
+				request.Respond(w, http.StatusOK, organisation)
 			}
-		} else {
-			request.Respond(w, http.StatusForbidden, "Access Denied - You are not the owner of the organisation")
 		}
 	}
 }
 
 func AddOrganisationModerator(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["id"])
-		userId, _ := uuid.Parse(vars["userId"])
-		currentUser := authentication.GetCurrentUser(w, r)
-
-		if utils.IsOrganisationOwnerOrGlobalAdmin(organisationId, currentUser) {
-			organisation := model.Organisation{}
-
-			if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	userId, _ := uuid.Parse(vars["userId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersManage) {
+		organisation := model.Organisation{}
+
+		if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+		} else {
+			if _, err := rbac.AssignRole(organisation, userId, rbac.RoleModerator, request.CurrentPrincipal(r)); err != nil {
+				respondWithRoleError(w, err)
 			} else {
-				newModerator := model.User{}
-				if err := db.DB.First(&newModerator, "Id = ?", userId); err.Error != nil {
-					sentryError := sentry.CaptureException(err.Error)
-					request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("User not found. Error code '%s'", *sentryError))
-				} else {
-					fmt.Println(newModerator.ID.String())
-					if err := db.DB.Model(&organisation).Omit("Moderators.*").Association("Moderators").Append(&newModerator); err != nil {
-						sentryError := sentry.CaptureException(err)
-						request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Could not add user as a Moderator for %s. Error code '%s'", organisation.Name, *sentryError))
-					} else {
-						request.Respond(w, http.StatusOK, fmt.Sprintf("User Id '%s' added to the Moderators list of organisation '%s'", userId, organisation.Name))
-					}
-				}
+				request.Respond(w, http.StatusOK, fmt.Sprintf("User Id '%s' added to the Moderators list of organisation '%s'", userId, organisation.Name))
 			}
-		} else {
-			request.Respond(w, http.StatusForbidden, "Access Denied - You are not the owner of the organisation")
 		}
 	}
 }
 
 func RemoveOrganisationModerator(w http.ResponseWriter, r *http.Request) {
-	if request.Authorize(w, r) {
-		vars := mux.Vars(r)
-		organisationId, _ := uuid.Parse(vars["id"])
-		userId, _ := uuid.Parse(vars["userId"])
-		currentUser := authentication.GetCurrentUser(w, r)
-
-		if utils.IsOrganisationOwnerOrGlobalAdmin(organisationId, currentUser) {
-			organisation := model.Organisation{}
-
-			if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
-				sentryError := sentry.CaptureException(err.Error)
-				request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+	userId, _ := uuid.Parse(vars["userId"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersManage) {
+		organisation := model.Organisation{}
+
+		if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+			sentryError := sentry.CaptureException(err.Error)
+			request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+		} else {
+			if err := rbac.RemoveMember(organisation, userId, request.CurrentPrincipal(r)); err != nil {
+				respondWithRoleError(w, err)
 			} else {
-				newModerator := model.User{}
-				if err := db.DB.First(&newModerator, "Id = ?", userId); err.Error != nil {
-					sentryError := sentry.CaptureException(err.Error)
-					request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("User not found. Error code '%s'", *sentryError))
-				} else {
-					if err := db.DB.Model(&organisation).Association("Moderators").Delete(&newModerator); err != nil {
-						sentryError := sentry.CaptureException(err)
-						request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Could not remove user from being a Moderator. Error code '%s'", *sentryError))
-					} else {
-						request.Respond(w, http.StatusOK, fmt.Sprintf("User Id '%s' removed from Moderators list of organisation '%s'", userId, organisation.Name))
-					}
-				}
+				request.Respond(w, http.StatusOK, fmt.Sprintf("User Id '%s' removed from Moderators list of organisation '%s'", userId, organisation.Name))
 			}
-		} else {
-			request.Respond(w, http.StatusForbidden, "Access Denied - You are not the owner of the organisation")
 		}
 	}
 }
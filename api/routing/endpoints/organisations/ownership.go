@@ -0,0 +1,166 @@
+package organisations
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/organisationmodel"
+	"github.com/benhall-1/appealscc/api/internal/ownership"
+	"github.com/benhall-1/appealscc/api/internal/rbac"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func GetPendingTransfer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationRead) {
+		if transfer, err := ownership.Pending(organisationId); err != nil {
+			respondWithTransferError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, transfer)
+		}
+	}
+}
+
+func GetOwnershipHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionMembersRead) {
+		if history, err := ownership.History(organisationId); err != nil {
+			respondWithTransferError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, history)
+		}
+	}
+}
+
+func TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationRead) {
+		var transferRequest organisationmodel.OwnershipTransferRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&transferRequest); err != nil {
+			sentryError := sentry.CaptureException(err)
+			request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+		} else {
+			defer r.Body.Close()
+
+			withOrganisation(w, organisationId, func(organisation model.Organisation) {
+				if transfer, err := ownership.Begin(organisation, transferRequest.UserId, request.CurrentPrincipal(r)); err != nil {
+					respondWithTransferError(w, err)
+				} else {
+					request.Respond(w, http.StatusOK, transfer)
+				}
+			})
+		}
+	}
+}
+
+func AcceptOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationRead) {
+		withOrganisation(w, organisationId, func(organisation model.Organisation) {
+			if transfer, err := ownership.Accept(organisation, request.CurrentPrincipal(r)); err != nil {
+				respondWithTransferError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, transfer)
+			}
+		})
+	}
+}
+
+func DeclineOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationRead) {
+		if err := ownership.Decline(organisationId, request.CurrentPrincipal(r)); err != nil {
+			respondWithTransferError(w, err)
+		} else {
+			request.Respond(w, http.StatusOK, "Ownership transfer declined")
+		}
+	}
+}
+
+func CancelOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	if request.RequirePermission(w, r, organisationId, rbac.PermissionOrganisationRead) {
+		withOrganisation(w, organisationId, func(organisation model.Organisation) {
+			if err := ownership.Cancel(organisation, request.CurrentPrincipal(r)); err != nil {
+				respondWithTransferError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, "Ownership transfer cancelled")
+			}
+		})
+	}
+}
+
+func OverrideOwnership(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	organisationId, _ := uuid.Parse(vars["id"])
+
+	var transferRequest organisationmodel.OwnershipTransferRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&transferRequest); err != nil {
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusBadRequest, fmt.Sprintf("Invalid body in request. Error code '%s'", *sentryError))
+	} else {
+		defer r.Body.Close()
+
+		withOrganisation(w, organisationId, func(organisation model.Organisation) {
+			if transfer, err := ownership.Override(organisation, transferRequest.UserId, request.CurrentPrincipal(r)); err != nil {
+				respondWithTransferError(w, err)
+			} else {
+				request.Respond(w, http.StatusOK, transfer)
+			}
+		})
+	}
+}
+
+func withOrganisation(w http.ResponseWriter, organisationId uuid.UUID, next func(organisation model.Organisation)) {
+	organisation := model.Organisation{}
+	if err := db.DB.First(&organisation, "Id = ?", organisationId); err.Error != nil {
+		sentryError := sentry.CaptureException(err.Error)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Organisation not found. Error code '%s'", *sentryError))
+	} else {
+		next(organisation)
+	}
+}
+
+func respondWithTransferError(w http.ResponseWriter, err error) {
+	switch err {
+	case ownership.ErrNotOwner:
+		request.Respond(w, http.StatusForbidden, "Access Denied - You are not the owner of the organisation")
+	case ownership.ErrNotGlobalAdmin:
+		request.Respond(w, http.StatusForbidden, "Access Denied - Only global admins can override ownership")
+	case ownership.ErrNotRecipient:
+		request.Respond(w, http.StatusForbidden, "Access Denied - This transfer was offered to someone else")
+	case ownership.ErrTransferToSelf:
+		request.Respond(w, http.StatusBadRequest, "This user already owns the organisation")
+	case ownership.ErrNotModerator:
+		request.Respond(w, http.StatusBadRequest, "Ownership can only be transferred to a moderator of the organisation")
+	case ownership.ErrTwoFactorRequired:
+		request.Respond(w, http.StatusBadRequest, "This organisation requires its owner to enable two-factor authentication")
+	case ownership.ErrTransferNotFound:
+		request.Respond(w, http.StatusNotFound, "There is no pending ownership transfer")
+	case ownership.ErrUserNotFound:
+		request.Respond(w, http.StatusNotFound, "User not found")
+	default:
+		sentryError := sentry.CaptureException(err)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error whilst transferring ownership. Error code '%s'", *sentryError))
+	}
+}
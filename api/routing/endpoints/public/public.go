@@ -0,0 +1,152 @@
+package public
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/benhall-1/appealscc/api/internal/db"
+	"github.com/benhall-1/appealscc/api/internal/models/model"
+	"github.com/benhall-1/appealscc/api/internal/models/organisationmodel"
+	"github.com/benhall-1/appealscc/api/internal/models/templatemodel"
+	"github.com/benhall-1/appealscc/api/internal/publishing"
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/benhall-1/appealscc/api/internal/versions"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Forms change rarely, so they can be cached briefly by browsers and CDNs
+const templateCacheAge = 5 * time.Minute
+
+func GetOrganisationBySlug(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if organisation, ok := organisationBySlug(w, vars["slug"]); ok {
+		respondWithOrganisation(w, r, *organisation)
+	}
+}
+
+// GetCurrentOrganisation returns the organisation served on the subdomain or
+// custom domain the request was made to.
+func GetCurrentOrganisation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Vary", "Host")
+
+	if organisation := request.CurrentOrganisation(r); organisation == nil {
+		request.Respond(w, http.StatusNotFound, "😢 No organisation is served on this domain")
+	} else {
+		respondWithOrganisation(w, r, *organisation)
+	}
+}
+
+func respondWithOrganisation(w http.ResponseWriter, r *http.Request, organisation model.Organisation) {
+	templates := []model.AppealTemplate{}
+	if err := publishedTemplates(organisation.ID).Find(&templates); err.Error != nil {
+		sentryError := sentry.CaptureException(err.Error)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting organisation. Error code '%s'", *sentryError))
+		return
+	}
+
+	summaries := []organisationmodel.PublicTemplateSummary{}
+	for _, template := range templates {
+		summaries = append(summaries, organisationmodel.PublicTemplateSummary{ID: template.ID, Name: template.Name, Open: template.IsOpen(time.Now())})
+	}
+
+	request.RespondCached(w, r, templateCacheAge, organisationmodel.PublicOrganisation{
+		ID:          organisation.ID,
+		Name:        organisation.Name,
+		Url:         organisation.Url,
+		IconHash:    organisation.IconHash,
+		Description: organisation.Description,
+		Verified:    organisation.Verified,
+		Templates:   summaries,
+	})
+}
+
+func GetOrganisationTemplates(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if organisation, ok := organisationBySlug(w, vars["slug"]); ok {
+		respondWithTemplates(w, r, organisation.ID)
+	}
+}
+
+func GetOrganisationTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	templateId, _ := uuid.Parse(vars["templateId"])
+
+	if organisation, ok := organisationBySlug(w, vars["slug"]); ok {
+		respondWithTemplate(w, r, organisation.ID, templateId)
+	}
+}
+
+func GetCurrentTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Vary", "Host")
+
+	if organisation := request.CurrentOrganisation(r); organisation == nil {
+		request.Respond(w, http.StatusNotFound, "😢 No organisation is served on this domain")
+	} else {
+		respondWithTemplates(w, r, organisation.ID)
+	}
+}
+
+func GetCurrentTemplate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Vary", "Host")
+	vars := mux.Vars(r)
+	templateId, _ := uuid.Parse(vars["templateId"])
+
+	if organisation := request.CurrentOrganisation(r); organisation == nil {
+		request.Respond(w, http.StatusNotFound, "😢 No organisation is served on this domain")
+	} else {
+		respondWithTemplate(w, r, organisation.ID, templateId)
+	}
+}
+
+func respondWithTemplates(w http.ResponseWriter, r *http.Request, organisationId uuid.UUID) {
+	templates := []model.AppealTemplate{}
+	if err := publishedTemplates(organisationId).Find(&templates); err.Error != nil {
+		sentryError := sentry.CaptureException(err.Error)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting appeal forms. Error code '%s'", *sentryError))
+		return
+	}
+
+	publicTemplates := []templatemodel.PublicTemplate{}
+	for _, template := range templates {
+		publicTemplates = append(publicTemplates, templatemodel.NewPublicTemplate(template))
+	}
+	request.RespondCached(w, r, templateCacheAge, publicTemplates)
+}
+
+func respondWithTemplate(w http.ResponseWriter, r *http.Request, organisationId uuid.UUID, templateId uuid.UUID) {
+	template := model.AppealTemplate{}
+	if result := publishedTemplates(organisationId).Find(&template, "id = ?", templateId); result.Error != nil {
+		sentryError := sentry.CaptureException(result.Error)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting appeal form. Error code '%s'", *sentryError))
+	} else if result.RowsAffected == 0 {
+		request.Respond(w, http.StatusNotFound, "😢 Appeal form not found")
+	} else {
+		request.RespondCached(w, r, templateCacheAge, templatemodel.NewPublicTemplate(template))
+	}
+}
+
+// publishedTemplates selects the organisation's templates that appellants can
+// fill in, including those outside their open and close window so appellants
+// can see when they open.
+func publishedTemplates(organisationId uuid.UUID) *gorm.DB {
+	return publishing.Published(versions.Preload(db.DB)).Where("organisation = ?", organisationId)
+}
+
+func organisationBySlug(w http.ResponseWriter, slug string) (*model.Organisation, bool) {
+	organisation := model.Organisation{}
+	if result := db.DB.Find(&organisation, "url = ?", slug); result.Error != nil {
+		sentryError := sentry.CaptureException(result.Error)
+		request.Respond(w, http.StatusInternalServerError, fmt.Sprintf("Error getting organisation. Error code '%s'", *sentryError))
+		return nil, false
+	} else if result.RowsAffected == 0 {
+		request.Respond(w, http.StatusNotFound, fmt.Sprintf("😢 No organisation uses the address '%s'", slug))
+		return nil, false
+	}
+	return &organisation, true
+}
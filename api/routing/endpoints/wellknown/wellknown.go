@@ -0,0 +1,16 @@
+package wellknown
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/benhall-1/appealscc/api/internal/tokens"
+)
+
+// JWKS serves the public signing keys as a bare JSON Web Key Set, the format
+// other services expect, rather than the usual API response envelope.
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens.JWKS())
+}
@@ -3,11 +3,15 @@ package routing
 import (
 	"net/http"
 
+	"github.com/benhall-1/appealscc/api/internal/request"
+	"github.com/benhall-1/appealscc/api/routing/endpoints/apikeys"
 	"github.com/benhall-1/appealscc/api/routing/endpoints/appeals"
 	"github.com/benhall-1/appealscc/api/routing/endpoints/appeals/templates"
 	"github.com/benhall-1/appealscc/api/routing/endpoints/auth"
 	"github.com/benhall-1/appealscc/api/routing/endpoints/index"
 	"github.com/benhall-1/appealscc/api/routing/endpoints/organisations"
+	"github.com/benhall-1/appealscc/api/routing/endpoints/public"
+	"github.com/benhall-1/appealscc/api/routing/endpoints/wellknown"
 
 	"github.com/gorilla/mux"
 )
@@ -15,37 +19,123 @@ import (
 func SetupRequests(router *mux.Router) {
 
 	router.Use(commonMiddleware)
+	router.Use(request.ResolveOrganisation)
+	router.Use(request.Authenticate)
 
 	// Define default API Routes
 	router.HandleFunc("/", index.HomePage)
+	request.Anonymous(router.HandleFunc("/.well-known/jwks.json", wellknown.JWKS).Methods("GET"))
 
-	// Define Appeals API Routes
-	router.HandleFunc("/api/appeals/{organisationId}", appeals.GetAllAppealsForOrganisation).Methods("GET")
-	router.HandleFunc("/api/appeals/{organisationId}/{appealId}", appeals.GetSingleAppeal).Methods("GET")
+	// Define Appeals API Routes, with the template routes first so "templates" is not matched as an appeal ID
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates", templates.GetAllTemplates).Methods("GET"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}", templates.GetTemplateById).Methods("GET"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/create", templates.CreateTemplate).Methods("POST"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/import", templates.ImportTemplate).Methods("POST"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/gallery/{starterId}", templates.CreateTemplateFromGallery).Methods("POST"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/update", templates.UpdateTemplate).Methods("PUT"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/delete", templates.DeleteTemplate).Methods("DELETE"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/reorder", templates.ReorderTemplate).Methods("PUT"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/versions", templates.GetTemplateVersions).Methods("GET"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/versions/{version}", templates.GetTemplateVersion).Methods("GET"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/versions/{version}/diff/{otherVersion}", templates.GetTemplateVersionDiff).Methods("GET"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/versions/{version}/rollback", templates.RollbackTemplate).Methods("POST"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/export", templates.ExportTemplate).Methods("GET"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/clone", templates.CloneTemplate).Methods("POST"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/status", templates.SetTemplateStatus).Methods("POST"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/schedule", templates.ScheduleTemplate).Methods("PUT"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}", appeals.GetAllAppealsForOrganisation).Methods("GET"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/{appealId}", appeals.GetSingleAppeal).Methods("GET"))
 	router.HandleFunc("/api/appeals/{organisationId}/create", appeals.CreateAppeal).Methods("POST")
-	router.HandleFunc("/api/appeals/{organisationId}/{appealId}/respond", appeals.AddAppealResponse).Methods("POST")
-	router.HandleFunc("/api/appeals/{organisationId}/templates", templates.GetAllTemplates).Methods("GET")
-	router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}", templates.GetTemplateById).Methods("GET")
-	router.HandleFunc("/api/appeals/{organisationId}/templates/create", templates.CreateTemplate).Methods("POST")
-	router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/update", templates.UpdateTemplate).Methods("PUT")
-	router.HandleFunc("/api/appeals/{organisationId}/templates/{templateId}/delete", templates.DeleteTemplate).Methods("DELETE")
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/{appealId}/respond", appeals.AddAppealResponse).Methods("POST"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/{appealId}/status", appeals.TransitionAppeal).Methods("POST"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/{appealId}/history", appeals.GetAppealHistory).Methods("GET"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/{appealId}/assign", appeals.AssignAppeal).Methods("PUT"))
+	request.AllowAPIKeys(router.HandleFunc("/api/appeals/{organisationId}/{appealId}/tags", appeals.TagAppeal).Methods("PUT"))
+
+	// Define Template Gallery Routes
+	request.Anonymous(router.HandleFunc("/api/templates/gallery", templates.GetGallery).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/templates/gallery/{starterId}", templates.GetGalleryTemplate).Methods("GET"))
 
 	// Define Authentication API Routes
-	router.HandleFunc("/api/auth/register", auth.Register).Methods("POST")
-	router.HandleFunc("/api/auth/refresh", auth.RefreshToken).Methods("POST")
-	router.HandleFunc("/api/auth/login", auth.Login).Methods("POST")
-	router.HandleFunc("/api/auth/discord", auth.LoginWithDiscord).Methods("GET")
-	router.HandleFunc("/api/auth/callback", auth.AuthCallback).Methods("GET")
+	request.Anonymous(router.HandleFunc("/api/auth/register", auth.Register).Methods("POST"))
+	request.Anonymous(router.HandleFunc("/api/auth/verify-email", auth.VerifyEmail).Methods("POST"))
+	request.Anonymous(router.HandleFunc("/api/auth/verify-email/resend", auth.ResendVerificationEmail).Methods("POST"))
+	router.HandleFunc("/api/auth/email", auth.SetEmail).Methods("POST")
+	request.Anonymous(router.HandleFunc("/api/auth/password/forgot", auth.ForgotPassword).Methods("POST"))
+	request.Anonymous(router.HandleFunc("/api/auth/password/reset", auth.ResetPassword).Methods("POST"))
+	router.HandleFunc("/api/auth/password/change", auth.ChangePassword).Methods("POST")
+	request.Anonymous(router.HandleFunc("/api/auth/refresh", auth.RefreshToken).Methods("POST"))
+	request.Anonymous(router.HandleFunc("/api/auth/login", auth.Login).Methods("POST"))
+	request.Anonymous(router.HandleFunc("/api/auth/login/2fa", auth.LoginWithTwoFactor).Methods("POST"))
+	router.HandleFunc("/api/auth/2fa/enrol", auth.EnrolTwoFactor).Methods("POST")
+	router.HandleFunc("/api/auth/2fa/confirm", auth.ConfirmTwoFactor).Methods("POST")
+	router.HandleFunc("/api/auth/2fa/disable", auth.DisableTwoFactor).Methods("POST")
+	router.HandleFunc("/api/auth/2fa/recovery-codes", auth.RegenerateRecoveryCodes).Methods("POST")
+	request.Anonymous(router.HandleFunc("/api/auth/logout", auth.Logout).Methods("POST"))
+	router.HandleFunc("/api/auth/logout/all", auth.LogoutAllSessions).Methods("POST")
+	request.Anonymous(router.HandleFunc("/api/auth/discord", auth.LoginWithDiscord).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/auth/callback", auth.AuthCallback).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/auth/twitch", auth.LoginWithTwitch).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/auth/twitch/callback", auth.TwitchCallback).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/auth/microsoft", auth.LoginWithMicrosoft).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/auth/microsoft/callback", auth.MicrosoftCallback).Methods("GET"))
+	router.HandleFunc("/api/auth/identities", auth.GetIdentities).Methods("GET")
+	router.HandleFunc("/api/auth/identities/{provider}/link", auth.LinkIdentity).Methods("POST")
+	router.HandleFunc("/api/auth/identities/{identityId}/unlink", auth.UnlinkIdentity).Methods("DELETE")
+
+	// Define API Key Routes
+	router.HandleFunc("/api/apikeys", apikeys.GetMyAPIKeys).Methods("GET")
+	router.HandleFunc("/api/apikeys/create", apikeys.CreateAPIKey).Methods("POST")
+	router.HandleFunc("/api/apikeys/{keyId}/revoke", apikeys.RevokeMyAPIKey).Methods("DELETE")
 
 	// Define Organisations API Routes
 	router.HandleFunc("/api/organisations/create", organisations.CreateOrganisation).Methods("POST")
 	router.HandleFunc("/api/organisations/{id}/update", organisations.UpdateOrganisation).Methods("PUT")
 	router.HandleFunc("/api/organisations/{id}/delete", organisations.DeleteOrganisation).Methods("DELETE")
+	router.HandleFunc("/api/organisations/{id}/2fa", organisations.UpdateTwoFactorRequirement).Methods("PUT")
 	router.HandleFunc("/api/organisations", organisations.GetAllOrganisations).Methods("GET")
 	router.HandleFunc("/api/organisations/byuser/{userId}", organisations.GetAllOrganisationsForUser).Methods("GET")
 	router.HandleFunc("/api/organisations/{id}", organisations.GetSingleOrganisation).Methods("GET")
+	router.HandleFunc("/api/organisations/{id}/apikeys", apikeys.GetOrganisationAPIKeys).Methods("GET")
+	router.HandleFunc("/api/organisations/{id}/apikeys/{keyId}/revoke", apikeys.RevokeOrganisationAPIKey).Methods("DELETE")
 	router.HandleFunc("/api/organisations/{id}/moderators/{userId}/add", organisations.AddOrganisationModerator).Methods("POST")
 	router.HandleFunc("/api/organisations/{id}/moderators/{userId}/remove", organisations.RemoveOrganisationModerator).Methods("DELETE")
+	router.HandleFunc("/api/organisations/{id}/members", organisations.GetMembers).Methods("GET")
+	router.HandleFunc("/api/organisations/{id}/members/{userId}/role", organisations.AssignMemberRole).Methods("PUT")
+	router.HandleFunc("/api/organisations/{id}/members/{userId}/remove", organisations.RemoveMember).Methods("DELETE")
+	router.HandleFunc("/api/organisations/{id}/domains", organisations.GetDomains).Methods("GET")
+	router.HandleFunc("/api/organisations/{id}/domains/create", organisations.AddDomain).Methods("POST")
+	router.HandleFunc("/api/organisations/{id}/domains/{domainId}/verify", organisations.VerifyDomain).Methods("POST")
+	router.HandleFunc("/api/organisations/{id}/domains/{domainId}/delete", organisations.RemoveDomain).Methods("DELETE")
+	router.HandleFunc("/api/organisations/{id}/transfer", organisations.GetPendingTransfer).Methods("GET")
+	router.HandleFunc("/api/organisations/{id}/transfer", organisations.TransferOwnership).Methods("POST")
+	router.HandleFunc("/api/organisations/{id}/transfer/accept", organisations.AcceptOwnershipTransfer).Methods("POST")
+	router.HandleFunc("/api/organisations/{id}/transfer/decline", organisations.DeclineOwnershipTransfer).Methods("POST")
+	router.HandleFunc("/api/organisations/{id}/transfer/cancel", organisations.CancelOwnershipTransfer).Methods("DELETE")
+	router.HandleFunc("/api/organisations/{id}/transfer/override", organisations.OverrideOwnership).Methods("POST")
+	router.HandleFunc("/api/organisations/{id}/ownership/history", organisations.GetOwnershipHistory).Methods("GET")
+	router.HandleFunc("/api/organisations/{id}/invites", organisations.GetPendingInvites).Methods("GET")
+	router.HandleFunc("/api/organisations/{id}/invites/create", organisations.CreateInvite).Methods("POST")
+	router.HandleFunc("/api/organisations/{id}/invites/{inviteId}/revoke", organisations.RevokeInvite).Methods("DELETE")
+	router.HandleFunc("/api/organisations/{id}/roles", organisations.GetRoles).Methods("GET")
+	router.HandleFunc("/api/organisations/{id}/roles/create", organisations.CreateRole).Methods("POST")
+	router.HandleFunc("/api/organisations/{id}/roles/{roleId}/update", organisations.UpdateRole).Methods("PUT")
+	router.HandleFunc("/api/organisations/{id}/roles/{roleId}/delete", organisations.DeleteRole).Methods("DELETE")
+
+	// Define Public Routes
+	request.Anonymous(router.HandleFunc("/api/public/organisation", public.GetCurrentOrganisation).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/public/organisations/{slug}", public.GetOrganisationBySlug).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/public/organisations/{slug}/templates", public.GetOrganisationTemplates).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/public/organisations/{slug}/templates/{templateId}", public.GetOrganisationTemplate).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/public/templates", public.GetCurrentTemplates).Methods("GET"))
+	request.Anonymous(router.HandleFunc("/api/public/templates/{templateId}", public.GetCurrentTemplate).Methods("GET"))
+
+	// Define Invite Routes
+	router.HandleFunc("/api/invites", organisations.GetMyInvites).Methods("GET")
+	router.HandleFunc("/api/invites/accept", organisations.AcceptInviteLink).Methods("POST")
+	router.HandleFunc("/api/invites/decline", organisations.DeclineInviteLink).Methods("POST")
+	router.HandleFunc("/api/invites/{inviteId}/accept", organisations.AcceptInvite).Methods("POST")
+	router.HandleFunc("/api/invites/{inviteId}/decline", organisations.DeclineInvite).Methods("POST")
 
 	// Handling Errors
 	router.NotFoundHandler = http.HandlerFunc(index.NotFound)